@@ -0,0 +1,348 @@
+package pal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/pal/audit"
+	"github.com/cloudflare/pal/log"
+	"github.com/cloudflare/pal/trustedlabels"
+)
+
+// Commands of the admin socket. Each connection carries a single adminRequest,
+// answered with a single adminResponse.
+const (
+	adminStatus    = "status"
+	adminReload    = "reload"
+	adminFlush     = "flush"
+	adminDecisions = "decisions"
+	adminLockdown  = "lockdown"
+	adminUnlock    = "unlock"
+)
+
+// Kinds of lockdown.
+const (
+	LockdownLabel = "label"
+	LockdownImage = "image"
+)
+
+type adminRequest struct {
+	Command string `json:"command"`
+	// Kind and Name are the label or image locked down or unlocked.
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Count is the number of recent decisions to list.
+	Count int `json:"count,omitempty"`
+}
+
+type adminResponse struct {
+	Error     string          `json:"error,omitempty"`
+	Status    *ServerStatus   `json:"status,omitempty"`
+	Decisions []*audit.Record `json:"decisions,omitempty"`
+}
+
+// ServerStatus describes a running pald.
+type ServerStatus struct {
+	Version    string        `json:"version"`
+	ConfigHash string        `json:"config_hash"`
+	Decrypters []string      `json:"decrypters"`
+	Uptime     time.Duration `json:"uptime"`
+	// LockedLabels and LockedImages are denied everything until unlocked.
+	LockedLabels []string `json:"locked_labels,omitempty"`
+	LockedImages []string `json:"locked_images,omitempty"`
+}
+
+// ServeAdmin serves the commands of palctl on the unix socket l. Only root, the
+// user running pald and the configured admin_uids may connect. version is
+// reported by the status command, and reload is called by the reload command.
+func (s *Server) ServeAdmin(l net.Listener, version string, reload func() error) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveAdminConn(c, version, reload)
+	}
+}
+
+func (s *Server) serveAdminConn(c net.Conn, version string, reload func() error) {
+	defer c.Close()
+	var resp *adminResponse
+	if err := s.authorizeAdmin(c); err != nil {
+		log.Errorf("Rejected admin connection: %v", err)
+		resp = &adminResponse{Error: err.Error()}
+	} else {
+		var req adminRequest
+		c.SetReadDeadline(time.Now().Add(s.readTimeout))
+		if err := json.NewDecoder(c).Decode(&req); err != nil {
+			resp = &adminResponse{Error: fmt.Sprintf("could not unmarshal JSON: %v", err)}
+		} else {
+			resp = s.adminCommand(&req, version, reload)
+		}
+	}
+	c.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	if err := json.NewEncoder(c).Encode(resp); err != nil {
+		log.Errorf("Failed to send admin response: %v", err)
+	}
+}
+
+// authorizeAdmin checks that the peer of c is root, the user running pald or
+// one of the admin uids.
+func (s *Server) authorizeAdmin(c net.Conn) error {
+	ucred, err := getUcred(c)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve peer credential of the connection: %v", err)
+	}
+	uid := int(ucred.Uid)
+	if uid == 0 || uid == os.Getuid() {
+		return nil
+	}
+	for _, allowed := range s.adminUIDs {
+		if uid == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("uid %d may not use the admin socket", uid)
+}
+
+func (s *Server) adminCommand(req *adminRequest, version string, reload func() error) *adminResponse {
+	var err error
+	resp := new(adminResponse)
+	switch req.Command {
+	case adminStatus:
+		resp.Status = s.status(version)
+	case adminReload:
+		if reload == nil {
+			err = errors.New("reload is not supported")
+		} else {
+			err = reload()
+		}
+	case adminFlush:
+		if r, ok := s.state().labelsRetriever.(trustedlabels.CachingRetriever); ok {
+			err = r.Flush()
+		}
+	case adminDecisions:
+		resp.Decisions = s.recent.latest(req.Count)
+	case adminLockdown, adminUnlock:
+		locked := req.Command == adminLockdown
+		if err = s.lockdown.set(req.Kind, req.Name, locked); err == nil {
+			log.Warningf("%s %s %s from the admin socket", req.Command, req.Kind, req.Name)
+		}
+	default:
+		err = fmt.Errorf("unknown admin command %q", req.Command)
+	}
+	if err != nil {
+		log.Errorf("Admin command %s failed: %v", req.Command, err)
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// status returns the status of s.
+func (s *Server) status(version string) *ServerStatus {
+	st := s.state()
+	status := &ServerStatus{
+		Version:    version,
+		ConfigHash: st.hash,
+		Uptime:     time.Since(s.started),
+	}
+	for name := range st.decrypters {
+		status.Decrypters = append(status.Decrypters, name)
+	}
+	sort.Strings(status.Decrypters)
+	status.LockedLabels, status.LockedImages = s.lockdown.list()
+	return status
+}
+
+// AdminClient sends commands to the admin socket of pald. It provides the core
+// functionality for the 'palctl' command line tool.
+type AdminClient struct {
+	socket string
+}
+
+// NewAdminClient returns an AdminClient of the admin socket at socketAddr.
+func NewAdminClient(socketAddr string) *AdminClient {
+	return &AdminClient{socket: socketAddr}
+}
+
+// Status returns the status of pald.
+func (c *AdminClient) Status() (*ServerStatus, error) {
+	resp, err := c.call(&adminRequest{Command: adminStatus})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Status, nil
+}
+
+// Reload makes pald reload its configuration.
+func (c *AdminClient) Reload() error {
+	_, err := c.call(&adminRequest{Command: adminReload})
+	return err
+}
+
+// Flush empties the caches of the labels retriever of pald.
+func (c *AdminClient) Flush() error {
+	_, err := c.call(&adminRequest{Command: adminFlush})
+	return err
+}
+
+// Decisions returns the latest n decisions of pald, oldest first, or all those
+// it remembers if n is zero.
+func (c *AdminClient) Decisions(n int) ([]*audit.Record, error) {
+	resp, err := c.call(&adminRequest{Command: adminDecisions, Count: n})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Decisions, nil
+}
+
+// Lockdown makes pald deny every secret of a label, or every secret to an
+// image, depending on kind, until it is unlocked.
+func (c *AdminClient) Lockdown(kind, name string) error {
+	_, err := c.call(&adminRequest{Command: adminLockdown, Kind: kind, Name: name})
+	return err
+}
+
+// Unlock lifts the lockdown of a label or an image.
+func (c *AdminClient) Unlock(kind, name string) error {
+	_, err := c.call(&adminRequest{Command: adminUnlock, Kind: kind, Name: name})
+	return err
+}
+
+func (c *AdminClient) call(req *adminRequest) (*adminResponse, error) {
+	conn, err := net.Dial("unix", c.socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send admin request: %v", err)
+	}
+	var resp adminResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read admin response: %v", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// lockdown holds the labels and images denied everything from the admin
+// socket. It survives reloads, but not restarts.
+type lockdown struct {
+	mu     sync.RWMutex
+	labels map[string]bool
+	images map[string]bool
+}
+
+func newLockdown() *lockdown {
+	return &lockdown{labels: make(map[string]bool), images: make(map[string]bool)}
+}
+
+// set locks down or unlocks the label or image name, depending on kind.
+func (l *lockdown) set(kind, name string, locked bool) error {
+	if name == "" {
+		return fmt.Errorf("missing %s to lock down or unlock", kind)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var m map[string]bool
+	switch kind {
+	case LockdownLabel:
+		m = l.labels
+	case LockdownImage:
+		m = l.images
+	default:
+		return fmt.Errorf("invalid lockdown kind %q, expected %s or %s", kind, LockdownLabel, LockdownImage)
+	}
+	if locked {
+		m[name] = true
+	} else {
+		delete(m, name)
+	}
+	return nil
+}
+
+// label reports whether label is locked down.
+func (l *lockdown) label(label string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.labels[label]
+}
+
+// image returns the name or digest of the image of identity if it is locked
+// down.
+func (l *lockdown) image(identity *trustedlabels.Identity) (string, bool) {
+	if identity == nil {
+		return "", false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, image := range []string{identity.ImageName, identity.ImageDigest} {
+		if image != "" && l.images[image] {
+			return image, true
+		}
+	}
+	return "", false
+}
+
+// list returns the labels and images locked down, sorted.
+func (l *lockdown) list() (labels, images []string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for label := range l.labels {
+		labels = append(labels, label)
+	}
+	for image := range l.images {
+		images = append(images, image)
+	}
+	sort.Strings(labels)
+	sort.Strings(images)
+	return labels, images
+}
+
+// recentDecisions is the number of decisions remembered for palctl.
+const recentDecisions = 1024
+
+// decisionLog remembers the latest audit records in a ring, whether or not an
+// audit log is configured.
+type decisionLog struct {
+	mu      sync.Mutex
+	records []*audit.Record
+	// next is the position of the next record once records is full.
+	next int
+}
+
+func newDecisionLog(size int) *decisionLog {
+	return &decisionLog{records: make([]*audit.Record, 0, size)}
+}
+
+func (d *decisionLog) add(rec *audit.Record) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.records) < cap(d.records) {
+		d.records = append(d.records, rec)
+		return
+	}
+	d.records[d.next] = rec
+	d.next = (d.next + 1) % len(d.records)
+}
+
+// latest returns the latest n records, oldest first, or all of them if n is
+// zero.
+func (d *decisionLog) latest(n int) []*audit.Record {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ordered := append(append([]*audit.Record(nil), d.records[d.next:]...), d.records[:d.next]...)
+	if n > 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
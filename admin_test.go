@@ -0,0 +1,94 @@
+package pal
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/pal/audit"
+
+	"github.com/joshlf/testutil"
+)
+
+func TestAdmin(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+	adminListener, admindir := mustListenUnixSocket(t)
+	defer os.RemoveAll(admindir)
+	defer adminListener.Close()
+
+	server := mustPGPServer(t, &identityRetriever{
+		Labels:    map[string]struct{}{"app-foo": {}},
+		ImageName: "foo:latest",
+	})
+	var reloads int
+	go server.ServeRPC(listener)
+	go server.ServeAdmin(adminListener, "1.2.3", func() error {
+		reloads++
+		return nil
+	})
+	client := NewAdminClient(adminListener.Addr().String())
+	decrypt := func() error {
+		config := &ConfigEntry{Envs: map[string]string{"FOO": mustPGPEncrypt(t, "foo", "app-foo")}}
+		return newClientV2(config, listener.Addr().String()).Decrypt()
+	}
+
+	status, err := client.Status()
+	testutil.MustPrefix(t, "could not get status", err)
+	if status.Version != "1.2.3" || status.ConfigHash != server.state().hash || !reflect.DeepEqual(status.Decrypters, []string{"pgp"}) {
+		t.Errorf("unexpected status %+v", status)
+	}
+	testutil.MustPrefix(t, "could not reload", client.Reload())
+	if reloads != 1 {
+		t.Errorf("want 1 reload, got %d", reloads)
+	}
+	testutil.MustPrefix(t, "could not flush", client.Flush())
+
+	testutil.MustPrefix(t, "could not decrypt secrets", decrypt())
+	tests := []struct {
+		kind, name string
+	}{
+		{LockdownLabel, "app-foo"},
+		{LockdownImage, "foo:latest"},
+	}
+	for _, test := range tests {
+		testutil.MustPrefix(t, "could not lock down", client.Lockdown(test.kind, test.name))
+		if err := decrypt(); err == nil || !strings.Contains(err.Error(), "code: 103") {
+			t.Errorf("want unauthorized error with %s %s locked down, got %v", test.kind, test.name, err)
+		}
+		testutil.MustPrefix(t, "could not unlock", client.Unlock(test.kind, test.name))
+		testutil.MustPrefix(t, fmt.Sprintf("could not decrypt secrets after unlocking %s", test.name), decrypt())
+	}
+	if err := client.Lockdown("container", "foo"); err == nil {
+		t.Error("want an error for an invalid lockdown kind")
+	}
+
+	records, err := client.Decisions(2)
+	testutil.MustPrefix(t, "could not list decisions", err)
+	if len(records) != 2 || records[0].Decision != audit.Deny || records[1].Decision != audit.Allow {
+		t.Fatalf("want a denial and an allow, got %+v", records)
+	}
+	if records[0].Image != "foo:latest" || records[0].Time.IsZero() {
+		t.Errorf("unexpected decision %+v", records[0])
+	}
+}
+
+func TestDecisionLog(t *testing.T) {
+	d := newDecisionLog(3)
+	for i := 0; i < 5; i++ {
+		d.add(&audit.Record{Seq: uint64(i)})
+	}
+	var seqs []uint64
+	for _, rec := range d.latest(0) {
+		seqs = append(seqs, rec.Seq)
+	}
+	if want := []uint64{2, 3, 4}; !reflect.DeepEqual(seqs, want) {
+		t.Errorf("want records %v, got %v", want, seqs)
+	}
+	if records := d.latest(1); len(records) != 1 || records[0].Seq != 4 {
+		t.Errorf("want the latest record, got %+v", records)
+	}
+}
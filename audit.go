@@ -0,0 +1,201 @@
+package pal
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cloudflare/pal/audit"
+	"github.com/cloudflare/pal/decrypter"
+	"github.com/cloudflare/pal/log"
+	"github.com/cloudflare/pal/trustedlabels"
+)
+
+// AuditSinkConfig configures an additional destination for the audit records,
+// besides the hash-chained audit log.
+type AuditSinkConfig struct {
+	// Type is syslog, journald or webhook.
+	Type string `yaml:"type"`
+	// Address is "unix:///dev/log" or "udp://host:port" for syslog, the path of
+	// the journal socket for journald (by default the systemd one), or the URL
+	// to POST records to for webhook.
+	Address string `yaml:"address,omitempty"`
+	// QueueDir is the directory holding the records a webhook has yet to
+	// deliver.
+	QueueDir string `yaml:"queue_dir,omitempty"`
+	// Decisions restricts the records shipped to this sink to the given
+	// decisions (allow, deny, error or alert), e.g. to only alert on denials.
+	Decisions []string `yaml:"decisions,omitempty"`
+}
+
+// newAuditSink returns the sink writing audit records to the log at path and to
+// the configured sinks, or nil if there are none.
+func newAuditSink(path string, configs []*AuditSinkConfig) (audit.Sink, error) {
+	var sinks audit.Multi
+	// the log comes first so that the other sinks receive chained records
+	if path != "" {
+		l, err := audit.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, l)
+	}
+	for _, config := range configs {
+		sink, err := config.newSink()
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return sinks, nil
+}
+
+func (c *AuditSinkConfig) newSink() (sink audit.Sink, err error) {
+	for _, d := range c.Decisions {
+		if d != audit.Allow && d != audit.Deny && d != audit.Error && d != audit.Alert {
+			return nil, fmt.Errorf("invalid audit decision %q for %s sink", d, c.Type)
+		}
+	}
+	switch c.Type {
+	case "syslog":
+		address := c.Address
+		if address == "" {
+			address = "unix:///dev/log"
+		}
+		sink, err = audit.NewSyslog(address, "pald")
+	case "journald":
+		sink, err = audit.NewJournald(c.Address, "pald")
+	case "webhook":
+		if c.Address == "" || c.QueueDir == "" {
+			return nil, fmt.Errorf("webhook audit sink requires an address and a queue_dir")
+		}
+		sink, err = audit.NewWebhook(c.Address, c.QueueDir)
+	default:
+		return nil, fmt.Errorf("invalid audit sink type %q", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Decisions) > 0 {
+		sink = audit.Filter(sink, c.Decisions...)
+	}
+	return sink, nil
+}
+
+// auditTrail collects the decisions taken while serving a single decryption
+// request and writes one audit record per requested secret.
+type auditTrail struct {
+	log      audit.Sink
+	recent   *decisionLog
+	c        *conn
+	dreq     *decryptionRequest
+	identity *trustedlabels.Identity
+	// secretLabels holds the labels of the secrets decrypted so far.
+	secretLabels map[string][]string
+}
+
+func (s *Server) newAuditTrail(c *conn, dreq *decryptionRequest) *auditTrail {
+	return &auditTrail{
+		log:          s.auditSink,
+		recent:       s.recent,
+		c:            c,
+		dreq:         dreq,
+		secretLabels: make(map[string][]string),
+	}
+}
+
+// labels records the labels of the decrypted secret k.
+func (t *auditTrail) labels(k string, labels []string) {
+	t.secretLabels[k] = labels
+}
+
+// allow records that every requested secret was returned to the caller.
+func (t *auditTrail) allow() {
+	t.write(func(string) (string, string) { return audit.Allow, "" })
+}
+
+// fail records that the request failed because of secret (or of no secret in
+// particular if it is empty) and returns the error response for the caller.
+// The other secrets of the request are recorded as denied, since none of them
+// is returned either.
+func (t *auditTrail) fail(decision string, code int, msg, secret string) *decryptionResponse {
+	log.Error(msg)
+	t.write(func(k string) (string, string) {
+		if secret == "" || k == secret {
+			return decision, msg
+		}
+		return audit.Deny, "request failed on secret " + secret
+	})
+	return &decryptionResponse{
+		Error: &decryptionError{
+			Code:    code,
+			Message: msg,
+			Secret:  secret,
+		},
+	}
+}
+
+// alert records an alert about secret, in addition to the decision that will
+// be taken for it.
+func (t *auditTrail) alert(secret, reason string) {
+	rec := t.record(secret)
+	rec.Decision, rec.Reason = audit.Alert, reason
+	if err := t.ship(rec); err != nil {
+		log.Errorf("Failed to write audit alert for %s: %v", secret, err)
+	}
+}
+
+func (t *auditTrail) write(decide func(k string) (decision, reason string)) {
+	keys := make([]string, 0, len(t.dreq.Ciphertexts))
+	for k := range t.dreq.Ciphertexts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		rec := t.record(k)
+		rec.Decision, rec.Reason = decide(k)
+		if err := t.ship(rec); err != nil {
+			log.Errorf("Failed to write audit record for %s: %v", k, err)
+		}
+	}
+}
+
+// ship writes rec to the audit sink, if any, and keeps it among the recent
+// decisions.
+func (t *auditTrail) ship(rec *audit.Record) error {
+	var err error
+	if t.log != nil {
+		err = t.log.Log(rec)
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	t.recent.add(rec)
+	return err
+}
+
+// record returns the record of secret k without a decision.
+func (t *auditTrail) record(k string) *audit.Record {
+	backend, _, _ := decrypter.SplitPALValue(t.dreq.Ciphertexts[k])
+	rec := &audit.Record{
+		Secret:  k,
+		Labels:  t.secretLabels[k],
+		Backend: backend,
+	}
+	if t.c.Ucred != nil {
+		rec.UID = int(t.c.Uid)
+		rec.PID = int(t.c.Pid)
+	}
+	if t.identity != nil {
+		rec.ContainerID = t.identity.ContainerID
+		rec.Image = t.identity.ImageName
+		rec.ImageDigest = t.identity.ImageDigest
+		rec.Certificate = t.identity.CertificateID
+	}
+	return rec
+}
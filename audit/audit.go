@@ -0,0 +1,213 @@
+// Package audit records the decryption decisions made by pald in a
+// tamper-evident log, and ships them to external sinks such as syslog,
+// journald or a webhook.
+//
+// The log is a file of JSON records, one per line. Every record carries the
+// SHA-256 hash of its own content chained with the hash of the previous record,
+// so that editing, reordering or deleting a record invalidates the hashes of all
+// the records that follow it.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Decisions recorded in a Record.
+const (
+	// Allow means the plaintext of the secret was returned to the caller.
+	Allow = "allow"
+	// Deny means the caller was not authorized to obtain the secret.
+	Deny = "deny"
+	// Error means the secret could not be decrypted.
+	Error = "error"
+	// Alert flags a request worth a closer look, such as an image requesting a
+	// label for the first time. It does not replace the decision taken for
+	// the secret, which is recorded separately.
+	Alert = "alert"
+)
+
+// genesisHash is the previous hash of the first record of a log.
+var genesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// A Record is the decision taken for a single secret of a single request.
+type Record struct {
+	Time time.Time `json:"time"`
+
+	UID int `json:"uid"`
+	PID int `json:"pid"`
+
+	ContainerID string `json:"container_id,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"image_digest,omitempty"`
+	// Certificate is the identity of the client certificate of a remote
+	// caller, whose UID and PID are zero.
+	Certificate string `json:"certificate,omitempty"`
+
+	// Secret is the name of the secret in the request: an environment variable
+	// or a file path.
+	Secret  string   `json:"secret"`
+	Labels  []string `json:"labels,omitempty"`
+	Backend string   `json:"backend,omitempty"`
+
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+
+	// Seq is the position of the record in the log, starting at 0.
+	Seq uint64 `json:"seq"`
+	// PrevHash is the Hash of the previous record.
+	PrevHash string `json:"prev_hash"`
+	// Hash is the hex-encoded SHA-256 hash of PrevHash and of the JSON encoding
+	// of the record with an empty Hash.
+	Hash string `json:"hash"`
+}
+
+// computeHash returns the chained hash of r. The Hash field is ignored.
+func (r Record) computeHash() (string, error) {
+	r.Hash = ""
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	io.WriteString(h, r.PrevHash)
+	h.Write(buf)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// A Logger appends hash-chained records to an audit log file. It is safe for
+// concurrent use. A Logger is a Sink; when combined with other sinks in a
+// Multi, it should come first so that the others receive chained records.
+type Logger struct {
+	mu       sync.Mutex
+	f        *os.File
+	seq      uint64
+	prevHash string
+	now      func() time.Time
+}
+
+// Open opens the audit log at path for appending, creating it if necessary.
+// If the log already holds records, the chain is continued from the last one.
+func Open(path string) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Logger{
+		f:        f,
+		prevHash: genesisHash,
+		now:      time.Now,
+	}
+
+	last, err := lastRecord(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to resume audit log %s: %v", path, err)
+	}
+	if last != nil {
+		l.seq = last.Seq + 1
+		l.prevHash = last.Hash
+	}
+	return l, nil
+}
+
+// lastRecord returns the last record of the log r, or nil if it is empty.
+func lastRecord(r io.Reader) (*Record, error) {
+	var last []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+	rec := new(Record)
+	if err := json.Unmarshal(last, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Log chains r to the previous record and appends it to the log. The Time, Seq,
+// PrevHash and Hash fields of r are set by Log.
+func (l *Logger) Log(r *Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r.Time.IsZero() {
+		r.Time = l.now().UTC()
+	}
+	r.Seq = l.seq
+	r.PrevHash = l.prevHash
+	hash, err := r.computeHash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	l.seq++
+	l.prevHash = hash
+	return nil
+}
+
+// Close closes the underlying log file.
+func (l *Logger) Close() error {
+	return l.f.Close()
+}
+
+// ErrBrokenChain is returned by Verify when the hash chain of a log is broken.
+var ErrBrokenChain = errors.New("audit log hash chain is broken")
+
+// Verify reads an audit log from r and checks its hash chain. It returns the
+// number of records and the hash of the last one, which may be compared to a
+// previously recorded value to detect the deletion of trailing records.
+func Verify(r io.Reader) (n int, lastHash string, err error) {
+	prevHash := genesisHash
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return n, prevHash, fmt.Errorf("line %d: %v", line, err)
+		}
+		if rec.Seq != uint64(n) || rec.PrevHash != prevHash {
+			return n, prevHash, fmt.Errorf("line %d: %v: record %d does not follow record %d",
+				line, ErrBrokenChain, rec.Seq, n-1)
+		}
+		hash, err := rec.computeHash()
+		if err != nil {
+			return n, prevHash, fmt.Errorf("line %d: %v", line, err)
+		}
+		if hash != rec.Hash {
+			return n, prevHash, fmt.Errorf("line %d: %v: record %d was modified", line, ErrBrokenChain, rec.Seq)
+		}
+		prevHash = rec.Hash
+		n++
+	}
+	return n, prevHash, scanner.Err()
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pal-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"A", "B"} {
+		if err := l.Log(&Record{Secret: secret, Decision: Allow}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// the chain must continue across restarts
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &Record{Secret: "C", Decision: Deny, Reason: "unauthorized"}
+	if err := l.Log(rec); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if rec.Seq != 2 {
+		t.Errorf("want seq 2 after reopening, got %d", rec.Seq)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, lastHash, err := Verify(bytes.NewReader(buf))
+	if err != nil || n != 3 || lastHash != rec.Hash {
+		t.Fatalf("want 3 valid records ending with %s, got %d, %s, %v", rec.Hash, n, lastHash, err)
+	}
+
+	lines := strings.SplitAfter(string(buf), "\n")
+	tampered := map[string]string{
+		"edited":    lines[0] + strings.Replace(lines[1], `"decision":"allow"`, `"decision":"deny"`, 1) + lines[2],
+		"deleted":   lines[0] + lines[2],
+		"reordered": lines[1] + lines[0] + lines[2],
+	}
+	for name, log := range tampered {
+		if _, _, err := Verify(strings.NewReader(log)); err == nil || !strings.Contains(err.Error(), ErrBrokenChain.Error()) {
+			t.Errorf("%s: want %v, got %v", name, ErrBrokenChain, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Sink receives audit records. Implementations must be safe for concurrent
+// use.
+type Sink interface {
+	// Log ships r. It may modify r, for instance to chain it to the previous
+	// record.
+	Log(r *Record) error
+	// Close releases the resources held by the Sink.
+	Close() error
+}
+
+// Multi is a Sink that ships every record to each of its sinks in order.
+type Multi []Sink
+
+// Log ships r to every sink, even if some of them fail. The returned error
+// describes all the failures.
+func (m Multi) Log(r *Record) error {
+	var errs []string
+	for _, s := range m {
+		if err := s.Log(r); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to ship audit record: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close closes every sink.
+func (m Multi) Close() error {
+	var errs []string
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to close audit sinks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+type filter struct {
+	Sink
+	decisions map[string]bool
+}
+
+// Filter returns a Sink that only ships to s the records with one of the given
+// decisions, e.g. to alert on denials only.
+func Filter(s Sink, decisions ...string) Sink {
+	f := &filter{Sink: s, decisions: make(map[string]bool)}
+	for _, d := range decisions {
+		f.decisions[d] = true
+	}
+	return f
+}
+
+func (f *filter) Log(r *Record) error {
+	if !f.decisions[r.Decision] {
+		return nil
+	}
+	return f.Sink.Log(r)
+}
+
+// severity maps a decision to a syslog severity.
+func severity(decision string) int {
+	switch decision {
+	case Allow:
+		return 6 // info
+	case Deny:
+		return 4 // warning
+	case Alert:
+		return 1 // alert
+	default:
+		return 3 // err
+	}
+}
+
+// message returns a human readable summary of r.
+func message(r *Record) string {
+	msg := fmt.Sprintf("%s secret %s for pid %d uid %d", r.Decision, r.Secret, r.PID, r.UID)
+	if r.ContainerID != "" {
+		msg += " in container " + r.ContainerID
+	}
+	if r.Certificate != "" {
+		msg += " with certificate " + r.Certificate
+	}
+	if r.Reason != "" {
+		msg += ": " + r.Reason
+	}
+	return msg
+}
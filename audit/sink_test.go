@@ -0,0 +1,213 @@
+package audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testRecord = &Record{
+	UID:         1000,
+	PID:         42,
+	ContainerID: "abcdef",
+	Secret:      "FOO",
+	Labels:      []string{"foo"},
+	Backend:     "pgp",
+	Decision:    Deny,
+	Reason:      "unauthorized label: foo]\"",
+}
+
+func TestSyslogSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := NewSyslog("udp://"+pc.LocalAddr().String(), "pald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Log(testRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// authpriv.warning
+	if !strings.HasPrefix(msg, "<84>1 ") {
+		t.Errorf("unexpected syslog header: %s", msg)
+	}
+	for _, want := range []string{" pald ", " deny [pal@32473 ", `decision="deny"`, `container_id="abcdef"`} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("want %q in syslog message: %s", want, msg)
+		}
+	}
+	var rec Record
+	if err := json.Unmarshal([]byte(msg[strings.Index(msg, "] {")+2:]), &rec); err != nil || rec.Reason != testRecord.Reason {
+		t.Errorf("want the JSON record as the syslog message, got %s (%v)", msg, err)
+	}
+
+	if _, err := NewSyslog("tcp://localhost:514", "pald"); err == nil {
+		t.Error("want an error for an unsupported syslog transport")
+	}
+}
+
+func TestJournaldSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pal-journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "socket")
+
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	j, err := NewJournald(path, "pald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	rec := *testRecord
+	rec.Reason = "multi\nline"
+	if err := j.Log(&rec); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := l.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	for _, want := range []string{
+		"PRIORITY=4\n",
+		"SYSLOG_IDENTIFIER=pald\n",
+		"PAL_DECISION=deny\n",
+		"PAL_SECRET=FOO\n",
+		"PAL_CONTAINER_ID=abcdef\n",
+		"PAL_REASON\n\x0a\x00\x00\x00\x00\x00\x00\x00multi\nline\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("want %q in journald datagram: %q", want, msg)
+		}
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pal-webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var received []string
+	failures := 2
+	done := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var rec Record
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			t.Error(err)
+		}
+		received = append(received, rec.Secret)
+		done <- struct{}{}
+	}))
+	defer srv.Close()
+
+	// a record queued by a previous run must be delivered first
+	w, err := NewWebhook(srv.URL, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := w.Log(&Record{Secret: "A", Decision: Deny}); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = NewWebhook(srv.URL, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Log(&Record{Secret: "B", Decision: Deny}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for webhook deliveries")
+		}
+	}
+	mu.Lock()
+	if strings.Join(received, ",") != "A,B" {
+		t.Errorf("want records A,B delivered in order, got %v", received)
+	}
+	mu.Unlock()
+
+	// the queue is drained once delivered
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		seqs, err := w.pending()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(seqs) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want an empty queue, got %v", seqs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFilterAndMulti(t *testing.T) {
+	var a, b recorder
+	m := Multi{&a, Filter(&b, Deny)}
+	for _, d := range []string{Allow, Deny, Error} {
+		if err := m.Log(&Record{Decision: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a) != 3 || len(b) != 1 || b[0].Decision != Deny {
+		t.Errorf("want 3 records and 1 denial, got %d and %v", len(a), b)
+	}
+}
+
+type recorder []*Record
+
+func (r *recorder) Log(rec *Record) error {
+	*r = append(*r, rec)
+	return nil
+}
+
+func (r *recorder) Close() error { return nil }
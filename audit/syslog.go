@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// facilityAuthPriv is the syslog facility used for audit records.
+const facilityAuthPriv = 10
+
+// sdID is the RFC 5424 structured data ID of audit records.
+const sdID = "pal@32473"
+
+// SyslogSink ships records as RFC 5424 syslog messages over a unix datagram
+// or UDP socket.
+type SyslogSink struct {
+	mu       sync.Mutex
+	network  string
+	addr     string
+	conn     net.Conn
+	hostname string
+	appName  string
+}
+
+// NewSyslog returns a Sink that sends records to the syslog daemon at address,
+// which is either "unix:///dev/log" or "udp://host:port".
+func NewSyslog(address, appName string) (*SyslogSink, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %v", address, err)
+	}
+	s := &SyslogSink{appName: appName}
+	switch u.Scheme {
+	case "unix":
+		s.network, s.addr = "unixgram", u.Path
+	case "udp":
+		s.network, s.addr = "udp", u.Host
+	default:
+		return nil, fmt.Errorf("invalid syslog address %q: unsupported scheme %q", address, u.Scheme)
+	}
+	if s.hostname, err = os.Hostname(); err != nil {
+		s.hostname = "-"
+	}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) dial() error {
+	conn, err := net.Dial(s.network, s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog at %s: %v", s.addr, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// Log sends r to syslog, reconnecting once if the socket was closed, e.g. by
+// a restart of the syslog daemon.
+func (s *SyslogSink) Log(r *Record) error {
+	msg, err := s.format(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err = s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	_, err = s.conn.Write(msg)
+	return err
+}
+
+// format returns the RFC 5424 message for r. The MSGID is the decision, the
+// structured data holds the main fields and the message is the JSON record.
+func (s *SyslogSink) format(r *Record) ([]byte, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	params := []string{
+		sdParam("decision", r.Decision),
+		sdParam("secret", r.Secret),
+		sdParam("uid", fmt.Sprint(r.UID)),
+		sdParam("pid", fmt.Sprint(r.PID)),
+	}
+	if r.ContainerID != "" {
+		params = append(params, sdParam("container_id", r.ContainerID))
+	}
+	if r.Certificate != "" {
+		params = append(params, sdParam("certificate", r.Certificate))
+	}
+	if r.Hash != "" {
+		params = append(params, sdParam("seq", fmt.Sprint(r.Seq)), sdParam("hash", r.Hash))
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s [%s %s] %s",
+		facilityAuthPriv*8+severity(r.Decision),
+		t.UTC().Format(time.RFC3339Nano),
+		s.hostname, s.appName, os.Getpid(), r.Decision,
+		sdID, strings.Join(params, " "), body)
+	return []byte(msg), nil
+}
+
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+func sdParam(name, value string) string {
+	return name + `="` + sdEscaper.Replace(value) + `"`
+}
+
+// Close closes the syslog socket.
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,217 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/pal/log"
+)
+
+const (
+	webhookMinBackoff = 100 * time.Millisecond
+	webhookMaxBackoff = time.Minute
+	webhookTimeout    = 10 * time.Second
+	queueSuffix       = ".json"
+)
+
+// WebhookSink POSTs every record as JSON to an HTTP endpoint. Records are first
+// written to a queue directory and delivered in order in the background, with
+// exponential backoff on failure, so that they survive both an unavailable
+// endpoint and a restart of pald.
+type WebhookSink struct {
+	url    string
+	dir    string
+	client *http.Client
+
+	minBackoff time.Duration
+
+	mu   sync.Mutex
+	next uint64
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewWebhook returns a Sink that delivers records to url, queueing them in dir.
+// Records left in dir by a previous run are delivered first.
+func NewWebhook(url, dir string) (*WebhookSink, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create webhook queue: %v", err)
+	}
+	w := &WebhookSink{
+		url:        url,
+		dir:        dir,
+		client:     &http.Client{Timeout: webhookTimeout},
+		minBackoff: webhookMinBackoff,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	pending, err := w.pending()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		w.next = pending[len(pending)-1] + 1
+	}
+	w.wg.Add(1)
+	go w.deliver()
+	return w, nil
+}
+
+// Log queues r for delivery.
+func (w *WebhookSink) Log(r *Record) error {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	// hold the lock until the record is visible, so that the delivery
+	// goroutine never sees record n+1 before record n
+	w.mu.Lock()
+	err = w.enqueue(w.next, buf)
+	if err == nil {
+		w.next++
+	}
+	w.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to queue audit record: %v", err)
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// enqueue writes the record buf as number n of the queue. It writes to a
+// temporary name first so that the delivery goroutine never sees a partial
+// record.
+func (w *WebhookSink) enqueue(n uint64, buf []byte) error {
+	name := filepath.Join(w.dir, fmt.Sprintf("%020d", n))
+	if err := ioutil.WriteFile(name+".tmp", buf, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name+queueSuffix); err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+	return nil
+}
+
+// pending returns the sorted sequence numbers of the queued records.
+func (w *WebhookSink) pending() ([]uint64, error) {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook queue: %v", err)
+	}
+	var seqs []uint64
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), queueSuffix) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), queueSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, n)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func (w *WebhookSink) deliver() {
+	defer w.wg.Done()
+	backoff := w.minBackoff
+	for {
+		err := w.flush()
+		wait := time.Duration(-1)
+		if err != nil {
+			log.Warningf("Failed to deliver audit records to %s, retrying in %v: %v", w.url, backoff, err)
+			wait = backoff
+			if backoff *= 2; backoff > webhookMaxBackoff {
+				backoff = webhookMaxBackoff
+			}
+		} else {
+			backoff = w.minBackoff
+		}
+
+		var retry <-chan time.Time
+		if wait >= 0 {
+			retry = time.After(wait)
+		}
+		select {
+		case <-w.done:
+			return
+		case <-w.wake:
+			if err != nil {
+				// keep backing off, a new record does not mean the
+				// endpoint is back
+				select {
+				case <-w.done:
+					return
+				case <-retry:
+				}
+			}
+		case <-retry:
+		}
+	}
+}
+
+// flush delivers the queued records in order, stopping at the first failure.
+func (w *WebhookSink) flush() error {
+	seqs, err := w.pending()
+	if err != nil {
+		return err
+	}
+	for _, n := range seqs {
+		select {
+		case <-w.done:
+			return nil
+		default:
+		}
+		name := filepath.Join(w.dir, fmt.Sprintf("%020d", n)+queueSuffix)
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := w.post(buf); err != nil {
+			return err
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WebhookSink) post(buf []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close stops the delivery of records. Records which were not delivered yet
+// remain in the queue for the next run.
+func (w *WebhookSink) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	return nil
+}
@@ -1,7 +1,12 @@
 package pal
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"os"
@@ -10,16 +15,59 @@ import (
 
 	"github.com/cloudflare/pal/log"
 	"github.com/mattn/go-shellwords"
+	"golang.org/x/crypto/nacl/box"
 )
 
 type clientV2 struct {
 	socketAddr string
 	dialFunc   func(network, addr string) (net.Conn, error)
 	config     *ConfigEntry
+
+	// session is the connection to a version 3 server, and server its hello.
+	// Both are nil when talking to a version 2 server.
+	session *session
+	server  *hello
+}
+
+// session is a connection on which several requests can be sent.
+type session struct {
+	net.Conn
+	decoder *json.Decoder
+	encoder *json.Encoder
+	lastID  uint64
+}
+
+func newSession(c net.Conn) *session {
+	return &session{
+		Conn:    c,
+		decoder: json.NewDecoder(c),
+		encoder: json.NewEncoder(c),
+	}
+}
+
+func (s *session) roundTrip(dreq *decryptionRequest) (*decryptionResponse, error) {
+	dresp := new(decryptionResponse)
+	if err := s.encoder.Encode(dreq); err != nil {
+		return nil, err
+	}
+	if err := s.decoder.Decode(dresp); err != nil {
+		log.Errorf("Failed to unmarshal PAL response: %v", err)
+		return nil, err
+	}
+	if dresp.ID == 0 && dresp.Error != nil {
+		// an error about the connection, such as a request pald could
+		// not read, rather than about dreq
+		return nil, dresp.Error
+	}
+	if dresp.ID != dreq.ID {
+		return nil, fmt.Errorf("got response %d to request %d", dresp.ID, dreq.ID)
+	}
+	return dresp, nil
 }
 
-// NewClientV2 constructs a new Client that implements version 2 of the PAL
-// protocol. r is a PAL YAML configuration, socketAddr is the file path to the
+// NewClientV2 constructs a new Client that implements versions 2 and 3 of the
+// PAL protocol. It uses version 3 if pald supports it, and version 2
+// otherwise. r is a PAL YAML configuration, socketAddr is the file path to the
 // pald socket, and appEnv is the environment from the config to use.
 //
 // If there is an error reading or parsing r, NewClientV2 will abort the
@@ -32,6 +80,21 @@ func NewClientV2(r io.Reader, socketAddr, appEnv string) Client {
 	return newClientV2(config, socketAddr)
 }
 
+// NewClientV2TLS is like NewClientV2, but connects to pald at the TCP address
+// addr using the given TLS configuration, which must include a client
+// certificate.
+func NewClientV2TLS(r io.Reader, addr, appEnv string, tlsConfig *tls.Config) Client {
+	config, err := loadConfigEntry(r, appEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := newClientV2(config, addr)
+	c.dialFunc = func(_, _ string) (net.Conn, error) {
+		return tls.Dial("tcp", addr, tlsConfig)
+	}
+	return c
+}
+
 func newClientV2(config *ConfigEntry, socketAddr string) *clientV2 {
 	return &clientV2{
 		socketAddr: socketAddr,
@@ -46,6 +109,12 @@ func newClientV2(config *ConfigEntry, socketAddr string) *clientV2 {
 // configuration for this Client. Upon success, the decrypted plaintexts are
 // stored for use in a future call to Exec.
 func (c *clientV2) Decrypt() (err error) {
+	if err := c.handshake(); err != nil {
+		log.Errorf("Failed to connect to pald: %v", err)
+		return err
+	}
+	defer c.closeSession()
+
 	if err := c.decryptMap(c.config.Envs); err != nil {
 		log.Errorf("Failed to decrypt env secrets: %v", err)
 		return err
@@ -126,40 +195,115 @@ func (c *clientV2) decryptMap(m map[string]string) error {
 	dreq := &decryptionRequest{
 		Ciphertexts: make(map[string]string),
 	}
+	var schemes []string
+	if c.server != nil {
+		schemes = c.server.Schemes
+	}
 	for k, v := range m {
-		if isSecret(v) {
+		if isSecret(v, schemes) {
 			dreq.Ciphertexts[k] = v
 		}
 	}
 
+	// seal the response to an ephemeral key if pald supports it
+	var sealKey *[32]byte
+	if c.server != nil && c.server.hasFeature(featureSeal) {
+		pub, priv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		defer zero(priv[:])
+		dreq.SealKey = base64.StdEncoding.EncodeToString(pub[:])
+		sealKey = priv
+	}
+
 	dresp, err := c.doRPCdecryptionRequest(dreq)
 	if err != nil {
 		return err
 	}
 
-	for k, v := range dresp.Secrets {
+	secrets := dresp.Secrets
+	if sealKey != nil {
+		if dresp.SealKey == "" {
+			return errors.New("pald did not seal the secrets of the response")
+		}
+		if secrets, err = openSecrets(sealKey, dresp.SealKey, dresp.Sealed); err != nil {
+			return err
+		}
+	}
+	for k, v := range secrets {
 		m[k] = v
 	}
 
 	return nil
 }
 
-func (c *clientV2) doRPCdecryptionRequest(dreq *decryptionRequest) (*decryptionResponse, error) {
+// handshake sends a version 3 hello to pald. If pald supports version 3, the
+// connection is kept for the decryption requests that follow. Otherwise the
+// client falls back to version 2, which uses a new connection per request.
+func (c *clientV2) handshake() error {
 	conn, err := c.dialFunc("unix", c.socketAddr)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer conn.Close()
-	var (
-		decoder = json.NewDecoder(conn)
-		encoder = json.NewEncoder(conn)
-		dresp   = new(decryptionResponse)
-	)
-	if err := encoder.Encode(dreq); err != nil {
-		return nil, err
+	s := newSession(conn)
+	dresp, err := s.roundTrip(&decryptionRequest{Hello: &hello{Version: protocolVersion}})
+	if err != nil {
+		conn.Close()
+		return err
 	}
-	if err := decoder.Decode(dresp); err != nil {
-		log.Errorf("Failed to unmarshal PAL response: %v", err)
+	if dresp.Error != nil {
+		// pald rejected the connection, for example because it could not
+		// identify the peer
+		conn.Close()
+		return dresp.Error
+	}
+	if dresp.Hello == nil {
+		// a version 2 server handled the hello as an empty decryption
+		// request and closed the connection
+		log.Debug("pald does not support protocol version 3, falling back to version 2")
+		conn.Close()
+		return nil
+	}
+	log.Debugf("Using protocol version 3 with pald: schemes %v, features %v", dresp.Hello.Schemes, dresp.Hello.Features)
+	c.session, c.server = s, dresp.Hello
+	return nil
+}
+
+func (c *clientV2) closeSession() {
+	if c.session == nil {
+		return
+	}
+	if err := c.session.Close(); err != nil {
+		log.Infof("failed to close connection: %v", err)
+	}
+	c.session, c.server = nil, nil
+}
+
+func (c *clientV2) doRPCdecryptionRequest(dreq *decryptionRequest) (*decryptionResponse, error) {
+	s := c.session
+	if s == nil {
+		conn, err := c.dialFunc("unix", c.socketAddr)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		s = newSession(conn)
+	} else {
+		s.lastID++
+		dreq.ID = s.lastID
+		if max := c.server.MaxMessageSize; max > 0 {
+			buf, err := json.Marshal(dreq)
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(buf)) > max {
+				return nil, fmt.Errorf("decryption request of %d bytes exceeds the maximum message size of pald (%d bytes)", len(buf), max)
+			}
+		}
+	}
+	dresp, err := s.roundTrip(dreq)
+	if err != nil {
 		return nil, err
 	}
 	if dresp.Error != nil {
@@ -168,16 +312,18 @@ func (c *clientV2) doRPCdecryptionRequest(dreq *decryptionRequest) (*decryptionR
 	return dresp, nil
 }
 
-func isSecret(v string) bool {
-	secretPrefixes := []string{
-		"ro:",
-		"ro+base64:",
-		"pgp:",
-		"pgp+base64:",
-	}
-	for _, prefix := range secretPrefixes {
-		if strings.HasPrefix(v, prefix) {
-			return true
+// secretSchemes are the secret schemes known to every version of pald.
+var secretSchemes = []string{"ro", "pgp"}
+
+// isSecret reports whether v is a secret, that is whether it starts with one of
+// the known schemes or one of the given schemes advertised by pald, followed by
+// ":" or "+base64:".
+func isSecret(v string, schemes []string) bool {
+	for _, list := range [][]string{secretSchemes, schemes} {
+		for _, scheme := range list {
+			if strings.HasPrefix(v, scheme+":") || strings.HasPrefix(v, scheme+"+base64:") {
+				return true
+			}
 		}
 	}
 	return false
@@ -17,6 +17,11 @@ Example usage:
 			PGP_VAR: pgp:production pgp encrypted blob
 	EOF
 	pal -socket=/var/run/pald.sock -env=prod -- env
+To debug the labels granted to a container, pal whoami prints what pald
+resolved for it: its container, image, digest, trust status, labels and the
+errors of the labels retriever. No secret is returned:
+	pal -socket=/var/run/pald.sock whoami
+The whoami command itself is still run with pal -- whoami.
 For possible flags and usage information, please see:
 	pal -h
 */
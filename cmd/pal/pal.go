@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/cloudflare/pal"
 	"github.com/cloudflare/pal/log"
@@ -14,9 +15,13 @@ var (
 	Version = "This is filled at build time"
 
 	env        = flag.String("env", "", "Environment name for config section (default is APP_ENV).")
-	socket     = flag.String("socket", "/run/pald/pald-rpc.sock", "Socket file for pald.")
-	socketType = flag.String("socket.type", "rpc", "Whether to communicate using rpc or http")
+	socket     = flag.String("socket", "/run/pald/pald-rpc.sock", "Socket file for pald, or tcp+tls://host:port for a remote pald.")
+	socketType = flag.String("socket.type", "rpc", "Whether to communicate using rpc (protocol version 3, or 2 with older pald) or http")
 	version    = flag.Bool("v", false, "show the version number and exit")
+
+	tlsCert = flag.String("tls.cert", "", "Client certificate to connect to a tcp+tls:// socket.")
+	tlsKey  = flag.String("tls.key", "", "Private key of the client certificate.")
+	tlsCA   = flag.String("tls.ca", "", "CA certificate of pald when connecting to a tcp+tls:// socket.")
 )
 
 func main() {
@@ -27,6 +32,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	// "pal -- whoami" still runs the whoami command
+	if flag.Arg(0) == "whoami" && flag.NArg() == 1 && os.Args[len(os.Args)-2] != "--" {
+		if err := runWhoAmI(); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
 	if appEnv := os.Getenv("APP_ENV"); *env == "" {
 		*env = appEnv
 	}
@@ -43,6 +56,14 @@ func main() {
 	var client pal.Client
 	switch *socketType {
 	case "rpc":
+		if addr := strings.TrimPrefix(*socket, "tcp+tls://"); addr != *socket {
+			tlsConfig, err := pal.NewClientTLSConfig(*tlsCert, *tlsKey, *tlsCA)
+			if err != nil {
+				log.Fatalf("Could not load TLS configuration: %v", err)
+			}
+			client = pal.NewClientV2TLS(bytes.NewBufferString(secretsYAML), addr, *env, tlsConfig)
+			break
+		}
 		client = pal.NewClientV2(bytes.NewBufferString(secretsYAML), *socket, *env)
 	case "http":
 		client = pal.NewClientV1(bytes.NewBufferString(secretsYAML), *socket, *env)
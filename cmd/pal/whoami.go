@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudflare/pal"
+)
+
+// queryWhoAmI sends a whoami request to the pald of -socket.
+func queryWhoAmI() (*pal.WhoAmI, error) {
+	if addr := strings.TrimPrefix(*socket, "tcp+tls://"); addr != *socket {
+		tlsConfig, err := pal.NewClientTLSConfig(*tlsCert, *tlsKey, *tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("could not load TLS configuration: %v", err)
+		}
+		return pal.QueryWhoAmITLS(addr, tlsConfig)
+	}
+	return pal.QueryWhoAmI(*socket)
+}
+
+// runWhoAmI implements the "whoami" subcommand of pal.
+func runWhoAmI() error {
+	w, err := queryWhoAmI()
+	if err != nil {
+		return err
+	}
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Printf("%-17s %s\n", name+":", value)
+		}
+	}
+	if w.PID != 0 {
+		field("Process", fmt.Sprintf("pid %d, uid %d, gid %d", w.PID, w.UID, w.GID))
+	}
+	field("Security context", w.SecurityContext)
+	field("Certificate", w.Certificate)
+	field("Container", w.ContainerID)
+	field("Image", w.Image)
+	field("Image digest", w.ImageDigest)
+	if w.Trusted {
+		field("Trust", "signed by "+w.SignerRole)
+	} else if w.Image != "" {
+		field("Trust", "not verified")
+	}
+	field("Pod", w.Pod)
+	if !w.LabelsEnabled {
+		field("Labels", "not checked by pald")
+	} else {
+		field("Labels", strings.Join(w.Labels, ", "))
+	}
+	labels := make([]string, 0, len(w.Sources))
+	for label := range w.Sources {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		field("  "+label, w.Sources[label])
+	}
+	for _, e := range w.Errors {
+		field("Error", e)
+	}
+	return nil
+}
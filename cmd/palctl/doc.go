@@ -0,0 +1,20 @@
+/*
+palctl controls a running pald through its admin socket, which pald serves
+with the -addr.admin flag. Only root, the user running pald and the admin_uids
+of its configuration may use it.
+Example usage:
+	pald -addr.rpc=unix:///run/pald/pald-rpc.sock -addr.admin=unix:///run/pald/pald-admin.sock -config=/etc/pal/config.yaml -env=prod
+	palctl status
+	palctl reload
+	palctl flush
+	palctl decisions 20
+A label or an image can be denied everything at once, e.g. while its secrets
+are being rotated, until it is unlocked. Lockdowns survive reloads, but not
+restarts:
+	palctl lockdown label prod-db
+	palctl lockdown image docker.io/library/foo:latest
+	palctl unlock label prod-db
+For possible flags and usage information, please see:
+	palctl -h
+*/
+package main
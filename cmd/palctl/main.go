@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/pal"
+	"github.com/cloudflare/pal/log"
+)
+
+var (
+	Version = "This is filled at build time"
+
+	socket  = flag.String("socket", "/run/pald/pald-admin.sock", "Admin socket of pald.")
+	version = flag.Bool("v", false, "show the version number and exit")
+)
+
+const usage = `usage: palctl [flags] <command>
+
+commands:
+	status                      show the version, configuration hash, decrypters, uptime and lockdowns of pald
+	reload                      reload the configuration of pald
+	flush                       flush the caches of the labels retriever
+	decisions [n]               list the latest n decisions, all those remembered by default
+	lockdown label|image <name> deny every secret of a label, or every secret to an image
+	unlock label|image <name>   lift a lockdown
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fmt.Fprintln(os.Stderr, "\nflags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("Version: %s\n", Version)
+		os.Exit(0)
+	}
+
+	if err := run(pal.NewAdminClient(*socket), flag.Args()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(client *pal.AdminClient, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "status":
+		status, err := client.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Version:       %s\n", status.Version)
+		fmt.Printf("Config hash:   %s\n", status.ConfigHash)
+		fmt.Printf("Decrypters:    %s\n", strings.Join(status.Decrypters, ", "))
+		fmt.Printf("Uptime:        %v\n", status.Uptime.Truncate(time.Second))
+		fmt.Printf("Locked labels: %s\n", strings.Join(status.LockedLabels, ", "))
+		fmt.Printf("Locked images: %s\n", strings.Join(status.LockedImages, ", "))
+	case "reload":
+		return client.Reload()
+	case "flush":
+		return client.Flush()
+	case "decisions":
+		var n int
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 0 {
+				return fmt.Errorf("invalid number of decisions %q", args[0])
+			}
+		}
+		records, err := client.Decisions(n)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			caller := fmt.Sprintf("uid=%d pid=%d", rec.UID, rec.PID)
+			if rec.Certificate != "" {
+				caller = "cert=" + rec.Certificate
+			}
+			if rec.Image != "" {
+				caller += " image=" + rec.Image
+			}
+			fmt.Printf("%s %-5s %s %s labels=%s %s\n", rec.Time.Format(time.RFC3339), rec.Decision,
+				rec.Secret, caller, strings.Join(rec.Labels, ","), rec.Reason)
+		}
+	case "lockdown", "unlock":
+		if len(args) != 2 || (args[0] != pal.LockdownLabel && args[0] != pal.LockdownImage) {
+			return fmt.Errorf("usage: palctl %s label|image <name>", cmd)
+		}
+		if cmd == "lockdown" {
+			return client.Lockdown(args[0], args[1])
+		}
+		return client.Unlock(args[0], args[1])
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
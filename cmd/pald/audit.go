@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cloudflare/pal/audit"
+)
+
+// runAudit implements the "audit" subcommand of pald.
+func runAudit(args []string) error {
+	if len(args) != 2 || args[0] != "verify" {
+		return errors.New("usage: pald audit verify <audit log>")
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, lastHash, err := audit.Verify(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v (after %d valid records)", args[1], err, n)
+	}
+	fmt.Printf("%s: %d records verified, last hash %s\n", args[1], n, lastHash)
+	return nil
+}
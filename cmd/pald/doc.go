@@ -4,19 +4,48 @@ listens on a unix socket which should be bind-mounted into any container
 wishing to decrypt secrets with pald.
 pald is configured using an yaml file. The configurations are separated by their environments.
 Possible configurations are:
-	- roserver: address of the redoctober server. Required if we need to decrypt RedOctober secrets
-	- ca: location of the certificates to communicate with RedOctober.
-	- ro_user: RedOctober username.
-	- ro_password: RedOctober password.
-	- pgp_keyring_path: path to the pgp secret keyring to decrypt pgp encrypted secrets.
-	- pgp_passphrase: passphrase to decrypt the keyring if required.
-	- pgp_cipher: pgp chosen cipher.
-	- pgp_hash: pgp chosen hash.
-	- labels_enabled: whether to enable trusted label checking.
-	- labels_retriever: use notary to trusted label checking. This is the only available scheme now.
-	- notary_trust_server: notary server to retrieve the trusted digest.
-	- notary_trust_dir: path to the directory for storing notary trust data.
+  - roserver: address of the redoctober server. Required if we need to decrypt RedOctober secrets
+  - ca: location of the certificates to communicate with RedOctober.
+  - ro_user: RedOctober username.
+  - ro_password: RedOctober password.
+  - pgp_keyring_path: path to the pgp secret keyring to decrypt pgp encrypted secrets.
+  - pgp_passphrase: passphrase to decrypt the keyring if required.
+  - pgp_cipher: pgp chosen cipher.
+  - pgp_hash: pgp chosen hash.
+  - labels_enabled: whether to enable trusted label checking.
+  - labels_retriever: docker uses notary to trusted label checking, composite combines several retrievers.
+  - labels_composite: the mode (union or intersect) and sources (docker, security_context or composite) of a composite retriever, see pal.CompositeConfig.
+  - notary_trust_server: notary server to retrieve the trusted digest.
+  - notary_trust_dir: path to the directory for storing notary trust data.
+  - notary_trust_cache_ttl: how long to reuse a verified image signature, persisted in notary_trust_dir.
+  - label_policies: per-label restrictions on the calling process, see pal.LabelPolicy.
+  - security_context_labels: labels granted by the caller's SELinux type, SELinux level or AppArmor profile.
+  - enforcement: off, shadow or enforce (the default) for the whole environment and per label prefix, see pal.EnforcementConfig. In shadow mode the labels and label policies are checked, but the would-be denials are only logged, audited as alerts and counted by the shadow_denials metric, and the secrets are still returned. In off mode the labels of the caller are not checked, but the label policies are still enforced.
+  - max_message_size: maximum size in bytes of an RPC request, 4MiB by default.
+  - max_secrets_per_request: maximum number of secrets in an RPC request, 256 by default.
+  - max_ciphertext_size: maximum size in bytes of a ciphertext, 1MiB by default.
+  - read_timeout: time allowed to read a request, 30s by default for RPC and unlimited for HTTP.
+  - write_timeout: time allowed to write a response, 30s by default for RPC and unlimited for HTTP.
+  - rpc_workers: number of RPC requests decrypted concurrently, 64 by default.
+  - rpc_max_connections: number of RPC connections served concurrently, 1024 by default.
+  - max_connections_per_uid: number of RPC connections served concurrently for a uid, unlimited by default.
+  - max_connections_per_container: number of RPC connections served concurrently for a container, unlimited by default. Connections and requests over a limit are counted by the rejections metric.
+  - rate_limits: token buckets (rate per second and burst) limiting the decryptions of each container, image and label, also counted by the rejections metric. A request with more secrets than the burst passes once the bucket is full, and only the secrets returned are charged. Remote callers are limited by the container limit for each certificate identity. The image limit requires labels_enabled.
+  - tripwire_file: file of the labels requested so far by each image digest; a label requested for the first time raises an alert audit record and is counted by the first_seen_labels metric.
+  - revocation_file: YAML list of revoked ciphertext SHA-256 hashes, labels and PGP key IDs, see pal.RevocationList. It is reloaded when it changes, and revoked secrets are answered with error code 106.
+  - decrypt_concurrency: number of secrets of a request decrypted concurrently, 8 by default.
+  - backend_concurrency: per backend (ro, pgp) number of concurrent decryptions, 32 by default.
+  - tls_cert, tls_key: server certificate of a tcp+tls:// RPC listener.
+  - tls_client_ca: CA that must have signed the client certificates of a tcp+tls:// RPC listener.
+  - certificate_labels: labels granted to remote callers by SPIFFE ID or "dns:" name, "*" matching any suffix.
+  - require_sealing: reject requests of clients which do not ask for their secrets to be sealed to an ephemeral key.
+  - disable_v1: reject requests using the legacy HTTP protocol; remaining usage is counted by the v1_requests metric.
+  - audit_log: path to the hash-chained audit log of every decryption decision.
+  - audit_sinks: syslog, journald or webhook destinations for the same records, see pal.AuditSinkConfig.
+  - admin_uids: uids allowed to use the admin socket besides root and the user running pald.
+
 Example configuration:
+
 	dev:
 		roserver: redoctober.local:8080
 		ca: /tmp/server.crt
@@ -36,8 +65,81 @@ Example configuration:
 		labels_retriever: docker
 		notary_trust_server: https://notary.docker.io
 		notary_trust_dir: .trust
+		notary_trust_cache_ttl: 1h
+		label_policies:
+			db-prod:
+				exe: [/usr/bin/myapp]
+				container_init: true
+				selinux_type: [container_t]
+		security_context_labels:
+			'selinux_level:s0:c123,c456': [db-prod]
+		max_connections_per_container: 32
+		read_timeout: 10s
+		rate_limits:
+			container: {rate: 5, burst: 20}
+			label: {rate: 100}
+		tripwire_file: /var/lib/pald/tripwire
+		tls_cert: /etc/pal/tls/pald.crt
+		tls_key: /etc/pal/tls/pald.key
+		tls_client_ca: /etc/pal/tls/clients-ca.crt
+		certificate_labels:
+			'spiffe://example.org/vm/db/*': [db-prod]
+		audit_log: /var/log/pald/audit.log
+		audit_sinks:
+			- type: journald
+			- type: webhook
+				address: https://siem.example.com/pal
+				queue_dir: /var/lib/pald/webhook
+				decisions: [deny, error, alert]
 Example usage:
 	pald -addr=unix:///var/run/pald.sock -config=/etc/pal/config.yaml -env=prod
+Remote callers, such as workloads on VMs, are served over mutual TLS on
+-addr.tls, alongside the local callers of -addr.rpc:
+	pald -addr.rpc=unix:///var/run/pald.sock -addr.tls=tcp+tls://0.0.0.0:8975 -config=/etc/pal/config.yaml -env=prod
+With -addr.grpc, pald also serves the gRPC service defined in proto/pald.proto,
+identifying its callers in the same way:
+	pald -addr.rpc=fd://3 -addr.grpc=unix:///run/pald/pald-grpc.sock -config=/etc/pal/config.yaml -env=prod
+On SIGHUP, pald reloads its configuration without dropping connections. The
+decrypters, labels retrievers and authorization settings are replaced, and the
+requests being served complete with the previous ones. If the new configuration
+is invalid, the current one is kept. The listeners, limits, rate limits,
+tripwire and audit settings are only read at startup. Reloads are counted by
+the config_reloads metric, and the config_hash metric identifies the
+configuration in use. The passwords are hashed with a key of the process, so
+that the hash changes when they are rotated, but differs between processes:
+	kill -HUP $(pidof pald)
+The metrics listener also serves /healthz, which checks that the RPC workers
+are not stalled, i.e. that requests waiting for a worker do not go 10s without
+any request completing, and /readyz, which checks that pald is not draining, that
+the PGP keyring is unlocked, that Red October accepts the configured
+credentials, that the Docker daemon and the trust server can be reached, and
+that neither the trust cache nor the TUF metadata of the repositories looked up
+since startup has gone stale.
+Both answer 200 or 503 with the status of each component in JSON:
+	curl http://127.0.0.1:8974/readyz
+Besides the counters above, the metrics listener exports the errors returned to
+clients by code and backend, the denials by label and image, the latency of
+requests, identity lookups and backend decryptions, the number of connections
+being served, and the hits, misses and entries of the labels retriever cache.
+On SIGTERM, pald stops accepting connections and reading requests, and exits
+once the requests in flight are answered, or after -shutdown-timeout. Under
+systemd, pald reports READY, RELOADING, STOPPING and STATUS to a Type=notify
+service, and pings the watchdog set by WatchdogSec unless its RPC workers have
+been stalled for half of it:
+	[Service]
+	Type=notify
+	ExecStart=/usr/bin/pald -addr.rpc=fd://3 -config=/etc/pal/config.yaml -env=prod
+	ExecReload=/bin/kill -HUP $MAINPID
+	WatchdogSec=30s
+With -addr.admin, pald serves an admin socket for palctl, which shows its
+status, reloads its configuration, flushes the labels retriever caches, lists
+the latest decisions and locks down labels or images:
+	pald -addr.rpc=fd://3 -addr.admin=unix:///run/pald/pald-admin.sock -config=/etc/pal/config.yaml -env=prod
+	palctl -socket=/run/pald/pald-admin.sock status
+The hash revoking a leaked ciphertext is that of its base64-decoded value:
+	echo 'pgp:...' | cut -d: -f2 | base64 -d | sha256sum
+The integrity of the audit log can be checked with:
+	pald audit verify /var/log/pald/audit.log
 For possible flags and usage information, please see:
 	pald -h
 */
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/cloudflare/pal"
 	"github.com/cloudflare/pal/log"
@@ -24,8 +26,13 @@ var (
 	env         = flag.String("env", "", "Environment name for config section (default is APP_ENV).")
 	httpAddr    = flag.String("addr.http", "", "Legacy HTTP Daemon socket to connect to. Accepted unix:///path or fd://n")
 	rpcAddr     = flag.String("addr.rpc", "", "RPC Daemon socket to connect to. Accepted unix:///path or fd://n")
-	metricsAddr = flag.String("metrics-addr", "127.0.0.1:8974", "HTTP listen address for metrics")
+	tlsAddr     = flag.String("addr.tls", "", "RPC Daemon address for remote callers, served alongside addr.rpc. Accepted tcp+tls://host:port")
+	grpcAddr    = flag.String("addr.grpc", "", "gRPC Daemon socket to connect to. Accepted unix:///path, fd://n or tcp+tls://host:port")
+	adminAddr   = flag.String("addr.admin", "", "Admin socket for palctl. Accepted unix:///path or fd://n")
+	metricsAddr = flag.String("metrics-addr", "127.0.0.1:8974", "HTTP listen address for metrics and the /healthz and /readyz probes")
 	version     = flag.Bool("v", false, "show the version number and exit")
+
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "Time allowed to answer the requests in flight on SIGTERM")
 )
 
 func main() {
@@ -36,6 +43,13 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "audit" {
+		if err := runAudit(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
 	r, err := os.Open(*config)
 	if err != nil {
 		log.Fatalf("Could not open server configuration file: %v", err)
@@ -56,8 +70,23 @@ func main() {
 	if *rpcAddr != "" {
 		addrs = append(addrs, *rpcAddr)
 	}
+	if *tlsAddr != "" {
+		addrs = append(addrs, *tlsAddr)
+	}
+	if *grpcAddr != "" {
+		addrs = append(addrs, *grpcAddr)
+	}
+	if *adminAddr != "" {
+		addrs = append(addrs, *adminAddr)
+	}
 
-	listeners, err := getListeners(addrs...)
+	if strings.HasPrefix(*httpAddr, "tcp+tls://") || strings.HasPrefix(*rpcAddr, "tcp+tls://") || strings.HasPrefix(*adminAddr, "tcp+tls://") {
+		log.Fatal("tcp+tls:// is only supported for addr.tls and addr.grpc")
+	}
+	if *tlsAddr != "" && !strings.HasPrefix(*tlsAddr, "tcp+tls://") {
+		log.Fatalf("Bad addr.tls %q, expected tcp+tls://host:port", *tlsAddr)
+	}
+	listeners, err := getListeners(conf, addrs...)
 	if err != nil {
 		log.Fatalf("Failed to get listener: %v", err)
 	}
@@ -65,39 +94,75 @@ func main() {
 		log.Fatalf("Failed to get any listener for %v ", addrs)
 	}
 
+	// the v1 API has no limits of its own
+	readTimeout, writeTimeout := srv.Timeouts()
+	hs := &http.Server{
+		Handler:      prometheus.InstrumentHandler("pald_HTTP", srv),
+		ConnContext:  srv.ConnContext,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+
 	errch := make(chan error)
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-c
-		for _, l := range listeners {
-			log.Infof("Closing %s", l.Addr())
-			if err := l.Close(); err != nil {
-				errch <- fmt.Errorf("Failed to close %s: %v", l.Addr(), err)
-				return
-			}
-		}
-		errch <- nil
+		errch <- serveMetrics(srv)
 	}()
 
-	go func() {
-		errch <- serveMetrics()
-	}()
+	for _, addr := range []string{*rpcAddr, *tlsAddr} {
+		if l, ok := listeners[addr]; ok {
+			go func(l net.Listener) {
+				log.Infof("Listening to rpc addr: %s", l.Addr())
+				if err := srv.ServeRPC(l); err != pal.ErrServerClosed {
+					errch <- err
+				}
+			}(l)
+		}
+	}
 
-	if l, ok := listeners[*rpcAddr]; ok {
+	if l, ok := listeners[*grpcAddr]; ok {
 		go func() {
-			log.Infof("Listening to rpc addr: %s", l.Addr())
-			errch <- srv.ServeRPC(l)
+			log.Infof("Listening to grpc addr: %s", l.Addr())
+			if err := srv.ServeGRPC(l); err != pal.ErrServerClosed {
+				errch <- err
+			}
 		}()
 	}
 
 	if l, ok := listeners[*httpAddr]; ok {
 		go func() {
 			log.Infof("Listening to http addr: %s", l.Addr())
-			errch <- http.Serve(l, prometheus.InstrumentHandler("pald_HTTP", srv))
+			if err := hs.Serve(l); err != http.ErrServerClosed {
+				errch <- err
+			}
 		}()
 	}
 
+	if l, ok := listeners[*adminAddr]; ok {
+		go func() {
+			log.Infof("Listening to admin addr: %s", l.Addr())
+			errch <- srv.ServeAdmin(l, Version, func() error { return reload(srv) })
+		}()
+	}
+
+	notify("READY=1\nSTATUS=" + statusServing)
+	go watchdog(srv)
+
+	// SIGHUP reloads the configuration; a failed reload keeps the current one
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range c {
+			if sig == syscall.SIGHUP {
+				if err := reload(srv); err != nil {
+					log.Errorf("Failed to reload configuration, keeping the current one: %v", err)
+				}
+				continue
+			}
+			errch <- shutdown(srv, hs)
+			return
+		}
+	}()
+
 	for err := range errch {
 		if err != nil {
 			log.Errorf("exit with error: %v", err)
@@ -107,13 +172,45 @@ func main() {
 	}
 }
 
-func serveMetrics() error {
-	return http.ListenAndServe(*metricsAddr, prometheus.Handler())
+// reload reloads the configuration of srv, on SIGHUP or from palctl.
+func reload(srv *pal.Server) error {
+	notify("RELOADING=1")
+	status := statusServing
+	err := srv.Reload(*config, *env)
+	if err != nil {
+		status += ", failed to reload configuration"
+	}
+	notify("READY=1\nSTATUS=" + status)
+	return err
+}
+
+// shutdown stops accepting connections and waits for the requests in flight to
+// be answered, for at most -shutdown-timeout.
+func shutdown(srv *pal.Server, hs *http.Server) error {
+	log.Info("Draining connections")
+	notify("STOPPING=1\nSTATUS=Draining connections")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if herr := hs.Shutdown(ctx); err == nil {
+		err = herr
+	}
+	return err
+}
+
+// serveMetrics serves the metrics, and the /healthz and /readyz probes.
+func serveMetrics(srv *pal.Server) error {
+	mux := http.NewServeMux()
+	mux.Handle("/", prometheus.Handler())
+	mux.HandleFunc("/healthz", srv.Healthz)
+	mux.HandleFunc("/readyz", srv.Readyz)
+	return http.ListenAndServe(*metricsAddr, mux)
 }
 
-func getListeners(addrs ...string) (map[string]net.Listener, error) {
+func getListeners(conf *pal.ServerConfigEntry, addrs ...string) (map[string]net.Listener, error) {
 	fdAddrs := []string{}
 	unixAddrs := []string{}
+	tlsAddrs := []string{}
 	listeners := make(map[string]net.Listener)
 
 	for _, addr := range addrs {
@@ -130,6 +227,8 @@ func getListeners(addrs ...string) (map[string]net.Listener, error) {
 			fdAddrs = append(fdAddrs, part)
 		case "unix":
 			unixAddrs = append(unixAddrs, part)
+		case "tcp+tls":
+			tlsAddrs = append(tlsAddrs, part)
 		}
 	}
 
@@ -147,6 +246,13 @@ func getListeners(addrs ...string) (map[string]net.Listener, error) {
 	for addr, l := range unixListeners {
 		listeners["unix://"+addr] = l
 	}
+	for _, addr := range tlsAddrs {
+		l, err := pal.ListenTLS(addr, conf)
+		if err != nil {
+			return nil, err
+		}
+		listeners["tcp+tls://"+addr] = l
+	}
 	return listeners, nil
 }
 
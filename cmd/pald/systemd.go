@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"github.com/cloudflare/pal"
+	"github.com/cloudflare/pal/log"
+	"github.com/coreos/go-systemd/daemon"
+)
+
+const statusServing = "Serving decryption requests"
+
+// notify sends state to systemd if pald runs as a Type=notify service.
+func notify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		log.Errorf("Failed to notify systemd: %v", err)
+	}
+}
+
+// watchdog pings the systemd watchdog, if WatchdogSec is set, as long as srv is
+// healthy, so that systemd restarts pald when it stops serving requests.
+func watchdog(srv *pal.Server) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Errorf("Failed to configure the systemd watchdog: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	healthy := true
+	for range time.Tick(interval / 2) {
+		if err := srv.Healthy(interval / 2); err != nil {
+			log.Errorf("Health check failed, not pinging the systemd watchdog: %v", err)
+			if healthy {
+				notify("STATUS=Unhealthy: " + err.Error())
+			}
+			healthy = false
+			continue
+		}
+		if !healthy {
+			notify("STATUS=" + statusServing)
+		}
+		healthy = true
+		notify("WATCHDOG=1")
+	}
+}
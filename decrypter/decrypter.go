@@ -19,6 +19,9 @@ func init() {
 type Secret struct {
 	Labels []string `json:"labels"`
 	Value  []byte   `json:"value"`
+	// KeyIDs are the IDs of the keys the secret was encrypted to, such as
+	// "6C7EE1B8621CC013" for PGP, if the backend has any.
+	KeyIDs []string `json:"-"`
 }
 
 // A Decrypter is a generic interface that abstracts away the details of
@@ -3,6 +3,8 @@ package decrypter
 import (
 	"crypto"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -66,9 +68,36 @@ func (d *pgpDecrypter) Decrypt(r io.Reader) (*Secret, error) {
 	if err := json.NewDecoder(md.UnverifiedBody).Decode(secret); err != nil {
 		return nil, err
 	}
+	for _, id := range md.EncryptedToKeyIds {
+		secret.KeyIDs = append(secret.KeyIDs, fmt.Sprintf("%016X", id))
+	}
 	return secret, nil
 }
 
+// Check reports whether the keyring holds a private key and all of its private
+// keys are unlocked.
+func (d *pgpDecrypter) Check() error {
+	found := false
+	for _, key := range d.keys {
+		if key.PrivateKey == nil {
+			continue
+		}
+		found = true
+		if key.PrivateKey.Encrypted {
+			return fmt.Errorf("private key %X is locked", key.PrimaryKey.KeyId)
+		}
+		for _, subkey := range key.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				return fmt.Errorf("private subkey %X is locked", subkey.PublicKey.KeyId)
+			}
+		}
+	}
+	if !found {
+		return errors.New("no private key in keyring")
+	}
+	return nil
+}
+
 // Convert a named hash algorithm into Go's hash algorithm enumeration for
 // parsing configuration
 func pgpHashIDFromName(hash string) crypto.Hash {
@@ -3,6 +3,7 @@ package decrypter
 import (
 	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/openpgp"
@@ -36,4 +37,33 @@ func TestPGPDecrypter(t *testing.T) {
 		t.Fatalf("wanted labels=%v value=%q, got label=%v value=%q", []string{"pal"},
 			"this is a test", sec.Labels, string(sec.Value))
 	}
+	if len(sec.KeyIDs) == 0 || len(sec.KeyIDs[0]) != 16 {
+		t.Errorf("want the 16 hex digit IDs of the recipient keys, got %v", sec.KeyIDs)
+	}
+}
+
+func TestPGPDecrypterCheck(t *testing.T) {
+	decrypter, err := NewPGPDecrypter("aes256", "sha256", "../testdata/secring.gpg", "paltest")
+	if err != nil {
+		t.Fatalf("failed to initialized pgp decrypter %v", err)
+	}
+	if err := decrypter.(*pgpDecrypter).Check(); err != nil {
+		t.Errorf("want unlocked keyring, got %v", err)
+	}
+
+	decrypter, err = NewPGPDecrypter("aes256", "sha256", "../testdata/secring.gpg", "")
+	if err != nil {
+		t.Fatalf("failed to initialized pgp decrypter %v", err)
+	}
+	if err := decrypter.(*pgpDecrypter).Check(); err == nil || !strings.Contains(err.Error(), "is locked") {
+		t.Errorf("want locked key error, got %v", err)
+	}
+
+	decrypter, err = NewPGPDecrypter("aes256", "sha256", "../testdata/pubring.gpg", "")
+	if err != nil {
+		t.Fatalf("failed to initialized pgp decrypter %v", err)
+	}
+	if err := decrypter.(*pgpDecrypter).Check(); err == nil || err.Error() != "no private key in keyring" {
+		t.Errorf("want no private key error, got %v", err)
+	}
 }
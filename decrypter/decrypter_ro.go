@@ -61,6 +61,13 @@ func (d *roDecrypter) Decrypt(ct io.Reader) (*Secret, error) {
 	}, nil
 }
 
+// Check reports whether the Red October server is reachable and accepts the
+// credentials of d.
+func (d *roDecrypter) Check() error {
+	_, err := d.server.Summary(core.SummaryRequest{Name: d.name, Password: d.password})
+	return err
+}
+
 func parseROLabels(data []byte) ([]string, error) {
 	sealedData := new(cryptor.EncryptedData)
 	if err := json.Unmarshal([]byte(data), sealedData); err != nil {
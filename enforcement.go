@@ -0,0 +1,86 @@
+package pal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Modes of label enforcement.
+const (
+	// EnforcementOff grants every label to every caller. The label policies
+	// are still enforced.
+	EnforcementOff = "off"
+	// EnforcementShadow checks the labels of the caller and the label
+	// policies, and logs, counts and audits the would-be denials, but still
+	// returns the secrets.
+	EnforcementShadow = "shadow"
+	// EnforcementEnforce denies the labels the caller is not granted. It is
+	// the default.
+	EnforcementEnforce = "enforce"
+)
+
+// EnforcementConfig sets how the labels of the secrets are enforced, to roll
+// out label checking gradually. The mode of a label is that of its longest
+// prefix in Prefixes, or Mode if none matches.
+//
+// The following enforces the db- labels, and only reports the denials of the
+// other labels:
+//  enforcement:
+//    mode: shadow
+//    prefixes:
+//      db-: enforce
+type EnforcementConfig struct {
+	Mode     string            `yaml:"mode,omitempty"`
+	Prefixes map[string]string `yaml:"prefixes,omitempty"`
+}
+
+func validEnforcementMode(mode string) bool {
+	return mode == EnforcementOff || mode == EnforcementShadow || mode == EnforcementEnforce
+}
+
+func (e *EnforcementConfig) validate() error {
+	if e.Mode != "" && !validEnforcementMode(e.Mode) {
+		return fmt.Errorf("invalid enforcement mode %q, expected off, shadow or enforce", e.Mode)
+	}
+	for prefix, mode := range e.Prefixes {
+		if !validEnforcementMode(mode) {
+			return fmt.Errorf("invalid enforcement mode %q for prefix %s, expected off, shadow or enforce", mode, prefix)
+		}
+	}
+	return nil
+}
+
+// mode returns the enforcement mode of label. A nil EnforcementConfig enforces
+// every label.
+func (e *EnforcementConfig) mode(label string) string {
+	if e == nil {
+		return EnforcementEnforce
+	}
+	mode, longest := e.Mode, -1
+	for prefix, m := range e.Prefixes {
+		if strings.HasPrefix(label, prefix) && len(prefix) > longest {
+			mode, longest = m, len(prefix)
+		}
+	}
+	if mode == "" {
+		return EnforcementEnforce
+	}
+	return mode
+}
+
+// strict reports whether every label is enforced, in which case a caller whose
+// identity cannot be resolved is denied every secret right away.
+func (e *EnforcementConfig) strict() bool {
+	if e == nil {
+		return true
+	}
+	if e.Mode != "" && e.Mode != EnforcementEnforce {
+		return false
+	}
+	for _, mode := range e.Prefixes {
+		if mode != EnforcementEnforce {
+			return false
+		}
+	}
+	return true
+}
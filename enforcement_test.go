@@ -0,0 +1,122 @@
+package pal
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/pal/audit"
+	"github.com/cloudflare/pal/trustedlabels"
+
+	"github.com/joshlf/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestEnforcementConfig(t *testing.T) {
+	var nilConfig *EnforcementConfig
+	if nilConfig.mode("db-prod") != EnforcementEnforce || !nilConfig.strict() {
+		t.Error("want a nil config to enforce every label")
+	}
+
+	config := &EnforcementConfig{
+		Mode: EnforcementShadow,
+		Prefixes: map[string]string{
+			"db-":      EnforcementEnforce,
+			"db-test-": EnforcementOff,
+		},
+	}
+	testutil.MustPrefix(t, "invalid config", config.validate())
+	tests := []struct {
+		label, want string
+	}{
+		{"app-foo", EnforcementShadow},
+		{"db-prod", EnforcementEnforce},
+		{"db-test-foo", EnforcementOff},
+	}
+	for _, test := range tests {
+		if got := config.mode(test.label); got != test.want {
+			t.Errorf("mode(%s): want %s, got %s", test.label, test.want, got)
+		}
+	}
+	if config.strict() {
+		t.Error("want a shadow config not to be strict")
+	}
+	if (&EnforcementConfig{Prefixes: map[string]string{"db-": EnforcementEnforce}}).mode("app-foo") != EnforcementEnforce {
+		t.Error("want labels without a mode to be enforced")
+	}
+
+	if err := (&EnforcementConfig{Mode: "dry-run"}).validate(); err == nil {
+		t.Error("want an error for an invalid mode")
+	}
+	if err := (&EnforcementConfig{Prefixes: map[string]string{"db-": "audit"}}).validate(); err == nil {
+		t.Error("want an error for an invalid prefix mode")
+	}
+}
+
+// unresolvedRetriever fails to identify any caller.
+type unresolvedRetriever struct{}
+
+func (unresolvedRetriever) IdentityForPID(int) (*trustedlabels.Identity, error) {
+	return nil, errors.New("image is not signed")
+}
+
+func TestServerShadowEnforcement(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, &identityRetriever{
+		Labels:    map[string]struct{}{"app-foo": {}},
+		ImageName: "foo:latest",
+	})
+	server.state().enforcement = &EnforcementConfig{
+		Mode:     EnforcementShadow,
+		Prefixes: map[string]string{"db-": EnforcementEnforce},
+	}
+	go server.ServeRPC(listener)
+	decrypt := func(label string) error {
+		config := &ConfigEntry{Envs: map[string]string{"FOO": mustPGPEncrypt(t, "foo", label)}}
+		return newClientV2(config, listener.Addr().String()).Decrypt()
+	}
+
+	testutil.MustPrefix(t, "could not decrypt shadowed label", decrypt("app-bar"))
+	var m dto.Metric
+	testutil.MustPrefix(t, "could not read metric", server.shadowDenials.WithLabelValues("app-bar", "foo:latest").Write(&m))
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("want 1 shadow denial of app-bar to foo:latest, got %v", got)
+	}
+	var alerted bool
+	for _, rec := range server.recent.latest(0) {
+		if rec.Decision == audit.Alert && strings.HasPrefix(rec.Reason, "shadow mode: ") {
+			alerted = true
+		}
+	}
+	if !alerted {
+		t.Error("want an audit alert for the shadow denial")
+	}
+	if err := decrypt("db-prod"); err == nil || !strings.Contains(err.Error(), "code: 103") {
+		t.Errorf("want unauthorized error for an enforced label, got %v", err)
+	}
+
+	// label policies are enforced even if the label is not
+	server.state().enforcement.Prefixes["app-"] = EnforcementOff
+	server.state().labelPolicies = map[string]*LabelPolicy{"app-bar": {Exe: []string{"/usr/bin/myapp"}}}
+	testutil.MustPrefix(t, "could not decrypt label without enforcement", decrypt("app-baz"))
+	if err := decrypt("app-bar"); err == nil || !strings.Contains(err.Error(), "code: 103") {
+		t.Errorf("want unauthorized error for a label policy, got %v", err)
+	}
+	delete(server.state().enforcement.Prefixes, "app-")
+	server.state().labelPolicies = nil
+
+	// callers which cannot be identified are granted no label
+	server.state().labelsRetriever = unresolvedRetriever{}
+	testutil.MustPrefix(t, "could not decrypt shadowed label for an unknown caller", decrypt("app-foo"))
+	if err := decrypt("db-prod"); err == nil || !strings.Contains(err.Error(), "code: 103") {
+		t.Errorf("want unauthorized error for an unknown caller, got %v", err)
+	}
+	server.state().enforcement = nil
+	if err := decrypt("app-foo"); err == nil || !strings.Contains(err.Error(), "code: 102") {
+		t.Errorf("want identity error when every label is enforced, got %v", err)
+	}
+}
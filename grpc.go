@@ -0,0 +1,255 @@
+package pal
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	palpb "github.com/cloudflare/pal/proto"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ServeGRPC serves the Pald gRPC service defined in proto/pald.proto. Like
+// with ServeRPC, callers are identified by the credentials of their end of the
+// socket when the connection is accepted, requests are decrypted by a fixed
+// pool of workers, and at most the configured number of connections are
+// served at once.
+//
+// After a call to Shutdown, ServeGRPC returns ErrServerClosed once the RPCs it
+// was serving are answered.
+func (s *Server) ServeGRPC(l net.Listener) error {
+	pool := newWorkerPool(s.rpcWorkers)
+	defer pool.stop()
+	if !s.trackListener(l, pool) {
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l, pool)
+
+	slots := make(chan struct{}, s.rpcMaxConnections)
+	gs := grpc.NewServer(grpc.Creds(&peerCredentials{s: s, slots: slots}), grpc.MaxMsgSize(int(s.maxMessageSize)))
+	palpb.RegisterPaldServer(gs, &grpcService{s: s, pool: pool})
+	err := gs.Serve(&slotListener{Listener: l, slots: slots, draining: s.draining})
+	if s.isDraining() {
+		gs.GracefulStop()
+		return ErrServerClosed
+	}
+	return err
+}
+
+// slotListener takes a slot before accepting a connection, so that further
+// connections wait in the listen backlog while all the slots are taken. The
+// slot is released by the transport credentials, which must see the accepted
+// connection itself to read its peer credentials.
+type slotListener struct {
+	net.Listener
+	slots    chan struct{}
+	draining <-chan struct{}
+}
+
+func (l *slotListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.draining:
+		return nil, ErrServerClosed
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+	}
+	return c, err
+}
+
+// peerAuthInfo is the identity of the peer of a gRPC connection, or why it
+// could not be identified or was rejected.
+type peerAuthInfo struct {
+	conn *conn
+	code int
+	err  error
+}
+
+func (*peerAuthInfo) AuthType() string {
+	return "peercred"
+}
+
+// peerCredentials are the transport credentials of the gRPC service. They do
+// not secure the connection, which is either a unix socket or already a TLS
+// connection, but identify its peer as identifyPeer does for ServeRPC, and
+// apply the limits of connections per uid and per container. Closing the
+// connection releases its slot of the slotListener.
+type peerCredentials struct {
+	s     *Server
+	slots chan struct{}
+}
+
+func (p *peerCredentials) ServerHandshake(c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	rc := &releasingConn{Conn: c, release: func() { <-p.slots }}
+	// the connection is accepted even if its peer is rejected, so that the
+	// error is returned to the client by its RPCs instead of a bare handshake
+	// failure
+	pc, err := identifyPeer(c)
+	if err != nil {
+		return rc, &peerAuthInfo{code: errCodeIdentity, err: err}, nil
+	}
+	release, reason, err := p.s.peers.acquire(pc)
+	if err != nil {
+		code := errCodeIdentity
+		if reason != "" {
+			p.s.rejections.WithLabelValues(reason).Inc()
+			code = errCodeLimit
+		}
+		return rc, &peerAuthInfo{code: code, err: err}, nil
+	}
+	p.s.connections.Inc()
+	rc.release = func() {
+		release()
+		p.s.connections.Dec()
+		<-p.slots
+	}
+	return rc, &peerAuthInfo{conn: pc}, nil
+}
+
+// ClientHandshake leaves the connection as is: the server reads the identity of
+// the client from the socket.
+func (*peerCredentials) ClientHandshake(_ context.Context, _ string, c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c, nil, nil
+}
+
+func (*peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (p *peerCredentials) Clone() credentials.TransportCredentials {
+	return &peerCredentials{s: p.s, slots: p.slots}
+}
+
+func (*peerCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// releasingConn calls release once when it is closed.
+type releasingConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *releasingConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// grpcService implements palpb.PaldServer on top of the JSON protocol handlers.
+type grpcService struct {
+	s    *Server
+	pool *workerPool
+}
+
+// peer returns the identity of the caller of the RPC of ctx.
+func (g *grpcService) peer(ctx context.Context) *peerAuthInfo {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(*peerAuthInfo); ok {
+			return info
+		}
+	}
+	return &peerAuthInfo{code: errCodeIdentity, err: errors.New("the peer of the connection is unknown")}
+}
+
+// run runs job on a worker of the pool and waits for it to return, or for ctx
+// to be done. In the latter case job still runs, but its result is dropped.
+func (g *grpcService) run(ctx context.Context, job func()) error {
+	done := make(chan struct{})
+	if !g.pool.trySubmit(func() { defer close(done); job() }, ctx.Done()) {
+		if err := ctx.Err(); err != nil {
+			return grpc.Errorf(codes.DeadlineExceeded, "no worker available: %v", err)
+		}
+		return grpc.Errorf(codes.Unavailable, "pald is shutting down")
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return grpc.Errorf(codes.DeadlineExceeded, "request not answered: %v", ctx.Err())
+	}
+}
+
+func (g *grpcService) Decrypt(ctx context.Context, req *palpb.DecryptRequest) (*palpb.DecryptResponse, error) {
+	info := g.peer(ctx)
+	if info.err != nil {
+		g.s.errors.WithLabelValues(strconv.Itoa(info.code), "").Inc()
+		return &palpb.DecryptResponse{Id: req.Id, Error: &palpb.Error{Code: int32(info.code), Message: info.err.Error()}}, nil
+	}
+	var dresp *decryptionResponse
+	err := g.run(ctx, func() {
+		dresp = g.s.decryptRequest(info.conn, &decryptionRequest{ID: req.Id, Ciphertexts: req.Ciphertexts})
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &palpb.DecryptResponse{Id: req.Id, Secrets: dresp.Secrets}
+	if e := dresp.Error; e != nil {
+		resp.Error = &palpb.Error{Code: int32(e.Code), Message: e.Message, Secret: e.Secret}
+	}
+	return resp, nil
+}
+
+func (g *grpcService) DecryptStream(stream palpb.Pald_DecryptStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		resp, err := g.Decrypt(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (g *grpcService) WhoAmI(ctx context.Context, _ *palpb.WhoAmIRequest) (*palpb.WhoAmIResponse, error) {
+	info := g.peer(ctx)
+	if info.err != nil {
+		return &palpb.WhoAmIResponse{Errors: []string{info.err.Error()}}, nil
+	}
+	var w *WhoAmI
+	if err := g.run(ctx, func() { w = g.s.whoAmI(info.conn).WhoAmI }); err != nil {
+		return nil, err
+	}
+	resp := &palpb.WhoAmIResponse{
+		Uid:             uint32(w.UID),
+		Gid:             uint32(w.GID),
+		Pid:             int32(w.PID),
+		ContainerId:     w.ContainerID,
+		ImageName:       w.Image,
+		ImageDigest:     w.ImageDigest,
+		SignerRole:      w.SignerRole,
+		Labels:          w.Labels,
+		Attributes:      w.Attributes,
+		SecurityContext: w.SecurityContext,
+		Certificate:     w.Certificate,
+		Trusted:         w.Trusted,
+		LabelsEnabled:   w.LabelsEnabled,
+		Sources:         w.Sources,
+		Errors:          w.Errors,
+	}
+	if i := strings.Index(w.Pod, "/"); i >= 0 {
+		resp.PodNamespace, resp.Pod = w.Pod[:i], w.Pod[i+1:]
+	}
+	return resp, nil
+}
+
+func (g *grpcService) Health(context.Context, *palpb.HealthRequest) (*palpb.HealthResponse, error) {
+	if g.s.readiness().Status != healthOK {
+		return &palpb.HealthResponse{Status: palpb.HealthResponse_NOT_SERVING}, nil
+	}
+	return &palpb.HealthResponse{Status: palpb.HealthResponse_SERVING}, nil
+}
@@ -0,0 +1,72 @@
+package pal
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	palpb "github.com/cloudflare/pal/proto"
+
+	"github.com/joshlf/testutil"
+	"google.golang.org/grpc"
+)
+
+func TestServerGRPC(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+
+	server := mustPGPServer(t, &identityRetriever{ContainerID: "abc", Labels: map[string]struct{}{"app-foo": {}}})
+	served := make(chan error, 1)
+	go func() { served <- server.ServeGRPC(listener) }()
+
+	cc, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", addr, timeout)
+	}))
+	testutil.MustPrefix(t, "could not connect to pald", err)
+	defer cc.Close()
+	client := palpb.NewPaldClient(cc)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.Decrypt(ctx, &palpb.DecryptRequest{Id: 1, Ciphertexts: map[string]string{"FOO": mustPGPEncrypt(t, "foo", "app-foo")}})
+	testutil.MustPrefix(t, "could not decrypt", err)
+	if resp.Error != nil || resp.Id != 1 || resp.Secrets["FOO"] != "foo" {
+		t.Errorf("want secret FOO decrypted, got %+v", resp)
+	}
+	resp, err = client.Decrypt(ctx, &palpb.DecryptRequest{Id: 2, Ciphertexts: map[string]string{"BAR": mustPGPEncrypt(t, "bar", "db-prod")}})
+	testutil.MustPrefix(t, "could not decrypt", err)
+	if resp.Error == nil || resp.Error.Code != errCodeUnauthorized || resp.Error.Secret != "BAR" || len(resp.Secrets) != 0 {
+		t.Errorf("want secret BAR denied, got %+v", resp)
+	}
+
+	stream, err := client.DecryptStream(ctx)
+	testutil.MustPrefix(t, "could not open stream", err)
+	for id := uint64(1); id <= 3; id++ {
+		testutil.MustPrefix(t, "could not send request", stream.Send(&palpb.DecryptRequest{Id: id, Ciphertexts: map[string]string{"FOO": mustPGPEncrypt(t, "foo", "app-foo")}}))
+		resp, err := stream.Recv()
+		testutil.MustPrefix(t, "could not receive response", err)
+		if resp.Id != id || resp.Secrets["FOO"] != "foo" {
+			t.Errorf("want secret FOO decrypted for request %d, got %+v", id, resp)
+		}
+	}
+	testutil.MustPrefix(t, "could not close stream", stream.CloseSend())
+
+	w, err := client.WhoAmI(ctx, &palpb.WhoAmIRequest{})
+	testutil.MustPrefix(t, "could not query whoami", err)
+	if int(w.Uid) != os.Getuid() || int(w.Pid) != os.Getpid() || w.ContainerId != "abc" || len(w.Labels) != 1 || w.Labels[0] != "app-foo" {
+		t.Errorf("want the identity of the test process, got %+v", w)
+	}
+
+	health, err := client.Health(ctx, &palpb.HealthRequest{})
+	testutil.MustPrefix(t, "could not check health", err)
+	if health.Status != palpb.HealthResponse_SERVING {
+		t.Errorf("want SERVING, got %v", health.Status)
+	}
+
+	testutil.MustPrefix(t, "could not drain server", server.Shutdown(ctx))
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("want ErrServerClosed, got %v", err)
+	}
+}
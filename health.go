@@ -0,0 +1,136 @@
+package pal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/pal/log"
+)
+
+// A Checker reports whether a component of pald is able to serve requests.
+// Decrypters and labels retrievers may implement it to take part in the
+// readiness checks, e.g. to check that a keyring is unlocked or that the
+// Docker daemon can be reached.
+type Checker interface {
+	Check() error
+}
+
+// Status of a health report or of one of its components.
+const (
+	healthOK        = "ok"
+	healthFail      = "fail"
+	healthUnchecked = "unchecked"
+)
+
+// healthCheckTimeout bounds the time taken by a health or readiness check.
+const healthCheckTimeout = 10 * time.Second
+
+type componentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status     string                      `json:"status"`
+	Components map[string]*componentHealth `json:"components"`
+}
+
+func (r *healthReport) set(component string, err error) {
+	if err == nil {
+		r.Components[component] = &componentHealth{Status: healthOK}
+		return
+	}
+	r.Components[component] = &componentHealth{Status: healthFail, Error: err.Error()}
+	r.Status = healthFail
+}
+
+// Healthz reports whether pald is alive, i.e. whether its RPC workers are not
+// stalled.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	report := &healthReport{Status: healthOK, Components: make(map[string]*componentHealth)}
+	report.set("rpc_workers", s.Healthy(healthCheckTimeout))
+	writeHealthReport(w, report)
+}
+
+// Readyz reports whether pald is ready to serve requests: it is not draining,
+// and its decrypters and labels retriever pass their checks. The components
+// which do not implement Checker are reported as unchecked.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, s.readiness())
+}
+
+// readiness checks the components of s for Readyz and the Health RPC.
+func (s *Server) readiness() *healthReport {
+	st := s.acquireState()
+	defer st.release()
+	components := make(map[string]interface{})
+	for name, d := range st.decrypters {
+		components["decrypter/"+name] = d
+	}
+	if st.labelsRetriever != nil {
+		components["labels_retriever"] = st.labelsRetriever
+	}
+
+	report := &healthReport{Status: healthOK, Components: make(map[string]*componentHealth)}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, component := range components {
+		checker, ok := component.(Checker)
+		if !ok {
+			mu.Lock()
+			report.Components[name] = &componentHealth{Status: healthUnchecked}
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			err := check(checker, healthCheckTimeout)
+			mu.Lock()
+			defer mu.Unlock()
+			report.set(name, err)
+		}(name)
+	}
+	wg.Wait()
+
+	if s.isDraining() {
+		report.set("server", fmt.Errorf("draining"))
+	} else {
+		report.set("server", nil)
+	}
+	return report
+}
+
+// check runs c.Check, giving up after timeout.
+func check(c Checker, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Check()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("check timed out after %v", timeout)
+	}
+}
+
+func writeHealthReport(w http.ResponseWriter, report *healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != healthOK {
+		for name, c := range report.Components {
+			if c.Status == healthFail {
+				log.Errorf("Health check of %s failed: %s", name, c.Error)
+			}
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Errorf("Failed to write health report: %v", err)
+	}
+}
@@ -1,24 +1,190 @@
 package pal
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"unsafe"
 )
 
+// soPeerSec is SO_PEERSEC, which the syscall package does not define.
+const soPeerSec = 0x1f
+
+// errPeerChanged is returned when the process at the other end of a connection
+// exited and its PID was reused while a request was being served.
+var errPeerChanged = errors.New("peer process changed during the request")
+
+// errRemotePeer is returned when inspecting the process of a peer connected
+// over TCP.
+var errRemotePeer = errors.New("peer is not a local process")
+
+// conn is a connection together with the identity of its peer. The peer is
+// either a local process identified by Ucred, or a remote caller identified by
+// the client certificate cert, in which case Ucred is nil.
 type conn struct {
 	net.Conn
 	*syscall.Ucred
+	cert *x509.Certificate
+	// startTime is the start time of the peer process when the connection was
+	// accepted. Together with the PID it uniquely identifies the peer.
+	startTime uint64
+	// securityContext is the LSM label of the peer, if any.
+	securityContext *securityContext
+	// proc is the peer process, inspected on first use by process. Requests
+	// on the same connection may be served concurrently, hence procMu.
+	procMu sync.Mutex
+	proc   *processInfo
 }
 
-func getUcred(conn net.Conn) (*syscall.Ucred, error) {
+// newConn pins the peer process of c so that a later call to verifyPeer can
+// detect PID reuse.
+func newConn(c net.Conn, ucred *syscall.Ucred) (*conn, error) {
+	startTime, err := procStartTime(int(ucred.Pid))
+	if err != nil {
+		return nil, err
+	}
+	return &conn{
+		Conn:      c,
+		Ucred:     ucred,
+		startTime: startTime,
+	}, nil
+}
+
+// verifyPeer checks that the PID of the peer still refers to the process that
+// was connected when the connection was accepted.
+func (c *conn) verifyPeer() error {
+	if c.Ucred == nil {
+		// a remote peer cannot be replaced while the connection is open
+		return nil
+	}
+	startTime, err := procStartTime(int(c.Pid))
+	if err != nil || startTime != c.startTime {
+		return errPeerChanged
+	}
+	return nil
+}
+
+// process returns the description of the peer process. /proc is only
+// inspected on the first call.
+func (c *conn) process() (*processInfo, error) {
+	if c.Ucred == nil {
+		return nil, errRemotePeer
+	}
+	c.procMu.Lock()
+	defer c.procMu.Unlock()
+	if c.proc == nil {
+		proc, err := readProcessInfo(int(c.Pid))
+		if err != nil {
+			return nil, err
+		}
+		c.proc = proc
+	}
+	return c.proc, nil
+}
+
+// rawConn returns the underlying socket of conn, which must be a unix socket.
+// Unlike (*net.UnixConn).File, it neither duplicates the file descriptor nor
+// switches it to blocking mode.
+func rawConn(conn net.Conn) (syscall.RawConn, error) {
 	uconn, ok := conn.(*net.UnixConn)
 	if !ok {
 		return nil, errors.New("internal listener is not a net.UnixListener")
 	}
-	f, err := uconn.File()
+	return uconn.SyscallConn()
+}
+
+func getUcred(conn net.Conn) (*syscall.Ucred, error) {
+	rc, err := rawConn(conn)
 	if err != nil {
 		return nil, err
 	}
-	return syscall.GetsockoptUcred(int(f.Fd()), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	var ucred *syscall.Ucred
+	cerr := rc.Control(func(fd uintptr) {
+		ucred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+	return ucred, err
+}
+
+// newTLSConn completes the handshake of c and identifies its peer by its
+// client certificate.
+func newTLSConn(c *tls.Conn) (*conn, error) {
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	certs := c.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no client certificate")
+	}
+	return &conn{Conn: c, cert: certs[0]}, nil
+}
+
+// getPeerSecurityContext returns the LSM label (SELinux context or AppArmor
+// profile) of the peer of conn as reported by SO_PEERSEC. It returns nil if
+// the host has no LSM that labels sockets.
+func getPeerSecurityContext(conn net.Conn) (*securityContext, error) {
+	rc, err := rawConn(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 256)
+	for {
+		n := uint32(len(buf))
+		var errno syscall.Errno
+		cerr := rc.Control(func(fd uintptr) {
+			_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.SOL_SOCKET,
+				soPeerSec, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&n)), 0)
+		})
+		if cerr != nil {
+			return nil, cerr
+		}
+		switch errno {
+		case 0:
+			raw := string(bytes.TrimRight(buf[:n], "\x00"))
+			if raw == "" {
+				return nil, nil
+			}
+			return parseSecurityContext(raw), nil
+		case syscall.ERANGE:
+			// n holds the required length
+			buf = make([]byte, n)
+		case syscall.ENOPROTOOPT:
+			return nil, nil
+		default:
+			return nil, errno
+		}
+	}
+}
+
+// procStartTime returns the time, in clock ticks after boot, at which pid
+// started. This is field 22 of /proc/<pid>/stat.
+func procStartTime(pid int) (uint64, error) {
+	buf, err := ioutil.ReadFile(procPath(pid, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The command name (field 2) is enclosed in parentheses and may itself
+	// contain spaces and parentheses, so skip past the last ')'.
+	stat := string(buf)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	// fields[0] is field 3 (state)
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
 }
@@ -0,0 +1,66 @@
+package pal
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/joshlf/testutil"
+)
+
+func TestVerifyPeer(t *testing.T) {
+	c, err := newConn(nil, &syscall.Ucred{Pid: int32(os.Getpid())})
+	if err != nil {
+		t.Fatalf("failed to pin own process: %v", err)
+	}
+	if err := c.verifyPeer(); err != nil {
+		t.Errorf("want unchanged peer, got %v", err)
+	}
+
+	// a different process reusing the PID has a different start time
+	c.startTime--
+	if err := c.verifyPeer(); err != errPeerChanged {
+		t.Errorf("want %v, got %v", errPeerChanged, err)
+	}
+
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	c, err = newConn(nil, &syscall.Ucred{Pid: int32(cmd.Process.Pid)})
+	if err != nil {
+		t.Fatalf("failed to pin child process: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.verifyPeer(); err != errPeerChanged {
+		t.Errorf("want %v for exited peer, got %v", errPeerChanged, err)
+	}
+}
+
+func TestGetPeerSecurityContext(t *testing.T) {
+	l, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer l.Close()
+
+	go func() {
+		c, err := net.Dial("unix", l.Addr().String())
+		if err == nil {
+			defer c.Close()
+			c.Read(make([]byte, 1))
+		}
+	}()
+	c, err := l.Accept()
+	testutil.MustPrefix(t, "could not accept", err)
+	defer c.Close()
+
+	// hosts without an LSM that labels sockets report no context
+	ctx, err := getPeerSecurityContext(c)
+	testutil.MustPrefix(t, "could not get peer security context", err)
+	if ctx != nil && ctx.Raw == "" {
+		t.Errorf("want nil or non-empty security context, got %+v", ctx)
+	}
+}
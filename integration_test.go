@@ -39,7 +39,7 @@ var (
 
 // returns the listener and the temporary directory that was created to contain
 // it; the listener should be closed and the directory deleted when finished
-func mustListenUnixSocket(t *testing.T) (net.Listener, string) {
+func mustListenUnixSocket(t testing.TB) (net.Listener, string) {
 	tempdir := testutil.MustTempDir(t, "", "pal-test")
 	l, err := net.Listen("unix", filepath.Join(tempdir, "pald.sock"))
 	testutil.MustPrefix(t, "could not listen on unix socket", err)
@@ -26,7 +26,7 @@ func TestServerWithClientV1(t *testing.T) {
 	testutil.MustPrefix(t, "could not create pald server", err)
 
 	go func() {
-		err := http.Serve(listener, server)
+		err := (&http.Server{Handler: server, ConnContext: server.ConnContext}).Serve(listener)
 		if err != nil {
 			t.Log(err)
 		}
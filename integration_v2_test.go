@@ -109,7 +109,7 @@ func TestRPCServerWithInvalidLabel(t *testing.T) {
 	}
 
 	err = newClientV2(config, listener.Addr().String()).Decrypt()
-	testutil.MustError(t, "code: 101, reason: Failed to decrypt secret: need more delegated keys", err)
+	testutil.MustError(t, "code: 104, reason: Failed to decrypt secret: need more delegated keys", err)
 }
 
 func TestRPCServerWithoutPeerCred(t *testing.T) {
@@ -154,5 +154,5 @@ func TestRPCServerWithoutPeerCred(t *testing.T) {
 		},
 	}
 	err = client.Decrypt()
-	testutil.MustError(t, "code: 101, reason: failed to retrieve peer credential of the connection: internal listener is not a net.UnixListener", err)
+	testutil.MustError(t, "code: 102, reason: failed to retrieve peer credential of the connection: internal listener is not a net.UnixListener", err)
 }
@@ -0,0 +1,81 @@
+package pal
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Reasons for rejecting a request, used as the label of the rejections metric.
+const (
+	rejectUIDConnections       = "uid_connections"
+	rejectContainerConnections = "container_connections"
+	rejectRequestSize          = "request_size"
+	rejectSecretCount          = "secret_count"
+	rejectCiphertextSize       = "ciphertext_size"
+	rejectTimeout              = "timeout"
+	rejectContainerRate        = "container_rate"
+	rejectImageRate            = "image_rate"
+	rejectLabelRate            = "label_rate"
+)
+
+// peerLimiter caps the number of concurrent connections of each uid and of
+// each container, so that a single misbehaving container cannot use up all the
+// connections pald serves.
+type peerLimiter struct {
+	maxPerUID       int
+	maxPerContainer int
+
+	mu         sync.Mutex
+	uids       map[uint32]int
+	containers map[string]int
+}
+
+func newPeerLimiter(maxPerUID, maxPerContainer int) *peerLimiter {
+	return &peerLimiter{
+		maxPerUID:       maxPerUID,
+		maxPerContainer: maxPerContainer,
+		uids:            make(map[uint32]int),
+		containers:      make(map[string]int),
+	}
+}
+
+// acquire reserves a connection for the peer of c. On success, the returned
+// function releases it. Otherwise, the reason is one of the reject constants.
+// Remote peers are not limited, since they have neither a uid nor a container.
+func (l *peerLimiter) acquire(c *conn) (release func(), reason string, err error) {
+	if c.Ucred == nil || (l.maxPerUID <= 0 && l.maxPerContainer <= 0) {
+		return func() {}, "", nil
+	}
+	var container string
+	if l.maxPerContainer > 0 {
+		if container, err = procContainerID(int(c.Pid)); err != nil {
+			return nil, "", fmt.Errorf("failed to find the container of peer process %d: %v", c.Pid, err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxPerUID > 0 && l.uids[c.Uid] >= l.maxPerUID {
+		return nil, rejectUIDConnections, fmt.Errorf("too many connections from uid %d", c.Uid)
+	}
+	if container != "" && l.containers[container] >= l.maxPerContainer {
+		return nil, rejectContainerConnections, fmt.Errorf("too many connections from container %s", container)
+	}
+	l.uids[c.Uid]++
+	if container != "" {
+		l.containers[container]++
+	}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.uids[c.Uid]--; l.uids[c.Uid] == 0 {
+			delete(l.uids, c.Uid)
+		}
+		if container == "" {
+			return
+		}
+		if l.containers[container]--; l.containers[container] == 0 {
+			delete(l.containers, container)
+		}
+	}, "", nil
+}
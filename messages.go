@@ -1,16 +1,132 @@
 package pal
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// protocolVersion is the latest version of the PAL RPC protocol.
+//
+// Version 2 is a single JSON request and response per connection. From version
+// 3 on, the client opens the connection with a hello request, to which the
+// server answers with its own hello describing what it supports, and then
+// sends any number of decryption requests, which it may pipeline. A version 2
+// server ignores the hello and answers with an empty response, so the client
+// can detect it and fall back to version 2.
+const protocolVersion = 3
 
 type decryptionRequest struct {
+	// ID identifies a version 3 request on its connection. It is echoed in
+	// the response, since responses to pipelined requests may be written in
+	// any order.
+	ID          uint64            `json:"id,omitempty"`
+	Hello       *hello            `json:"hello,omitempty"`
 	Ciphertexts map[string]string `json:"ciphertexts,omitempty"`
+	// SealKey is the base64-encoded ephemeral X25519 public key to seal the
+	// secrets of the response to, if the server supports sealing.
+	SealKey string `json:"seal_key,omitempty"`
+	// WhoAmI asks for what pald resolved for the caller instead of decrypting
+	// Ciphertexts, see WhoAmI.
+	WhoAmI bool `json:"whoami,omitempty"`
 }
 
 type decryptionResponse struct {
+	ID      uint64            `json:"id,omitempty"`
+	Hello   *hello            `json:"hello,omitempty"`
 	Error   *decryptionError  `json:"error,omitempty"`
 	Secrets map[string]string `json:"secrets,omitempty"`
+	// SealKey is the base64-encoded ephemeral X25519 public key of the server
+	// with which the secrets in Sealed were sealed, when the request asked
+	// for it. Secrets is then empty.
+	SealKey string            `json:"seal_key,omitempty"`
+	Sealed  map[string]string `json:"sealed,omitempty"`
+	WhoAmI  *WhoAmI           `json:"whoami,omitempty"`
+}
+
+// hello is exchanged at the start of a version 3 connection. The client only
+// sets Version; the server describes itself.
+type hello struct {
+	Version int `json:"version"`
+	// Schemes are the prefixes of the secrets the server can decrypt, such as
+	// "pgp" for "pgp:" and "pgp+base64:" values.
+	Schemes []string `json:"schemes,omitempty"`
+	// MaxMessageSize is the maximum size in bytes of a request.
+	MaxMessageSize int64 `json:"max_message_size,omitempty"`
+	// Features are the optional behaviours enabled on the server.
+	Features []string `json:"features,omitempty"`
+}
+
+// Features advertised in a server hello.
+const (
+	// featureLabels means the labels of the secrets are checked against the
+	// labels of the caller.
+	featureLabels = "labels"
+	// featureAudit means every decision is written to an audit log.
+	featureAudit = "audit"
+	// featurePipelining means several requests may be sent without waiting
+	// for their responses.
+	featurePipelining = "pipelining"
+	// featureSeal means the secrets of a response can be sealed to a key of
+	// the client, see seal.go.
+	featureSeal = "seal"
+	// featureWhoAmI means the server answers whoami requests.
+	featureWhoAmI = "whoami"
+)
+
+func (h *hello) hasFeature(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+var errMessageTooLarge = errors.New("message exceeds the maximum message size")
+
+// messageReader limits the number of bytes read for each message. The limit is
+// approximate since a JSON decoder reads ahead, but it bounds the memory a
+// single request may use.
+type messageReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+// reset starts a new message.
+func (m *messageReader) reset() {
+	m.n = 0
 }
 
+func (m *messageReader) Read(p []byte) (int, error) {
+	if m.n >= m.limit {
+		return 0, errMessageTooLarge
+	}
+	if int64(len(p)) > m.limit-m.n {
+		p = p[:m.limit-m.n]
+	}
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	return n, err
+}
+
+// Error codes of a decryptionError.
+const (
+	// errCodeGeneric is a malformed request or an internal error.
+	errCodeGeneric = 101
+	// errCodeIdentity means the peer or its labels could not be identified.
+	errCodeIdentity = 102
+	// errCodeUnauthorized means the peer may not obtain a secret.
+	errCodeUnauthorized = 103
+	// errCodeDecrypt means a backend failed to decrypt a secret.
+	errCodeDecrypt = 104
+	// errCodeLimit means the request exceeded a limit or a rate limit.
+	errCodeLimit = 105
+	// errCodeRevoked means a secret, its label or its key is revoked.
+	errCodeRevoked = 106
+)
+
 type decryptionError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
@@ -0,0 +1,53 @@
+package pal
+
+import (
+	"github.com/cloudflare/pal/log"
+	"github.com/cloudflare/pal/trustedlabels"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deny counts label denied to the caller identity.
+func (s *Server) deny(identity *trustedlabels.Identity, label string) {
+	var image string
+	if identity != nil {
+		image = identity.ImageName
+	}
+	s.denials.WithLabelValues(label, image).Inc()
+}
+
+// shadowDeny logs and counts label that would have been denied to the caller
+// identity for the reason msg, if it were enforced.
+func (s *Server) shadowDeny(identity *trustedlabels.Identity, label, msg string) {
+	var image string
+	if identity != nil {
+		image = identity.ImageName
+	}
+	log.Warningf("Shadow mode, returning a secret which would be denied: %s", msg)
+	s.shadowDenials.WithLabelValues(label, image).Inc()
+}
+
+// retrieverCacheMetrics returns the metrics of the cache of the current labels
+// retriever, if it has one.
+func (s *Server) retrieverCacheMetrics() []prometheus.Collector {
+	stats := func() trustedlabels.CacheStats {
+		if r, ok := s.state().labelsRetriever.(trustedlabels.CachingRetriever); ok {
+			return r.CacheStats()
+		}
+		return trustedlabels.CacheStats{}
+	}
+	return []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "retriever_cache_hits",
+			Help: "Lookups of the labels retriever answered from its cache",
+		}, func() float64 { return float64(stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "retriever_cache_misses",
+			Help: "Lookups of the labels retriever not answered from its cache",
+		}, func() float64 { return float64(stats().Misses) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "retriever_cache_entries",
+			Help: "Entries in the cache of the labels retriever",
+		}, func() float64 { return float64(stats().Entries) }),
+	}
+}
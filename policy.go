@@ -0,0 +1,129 @@
+package pal
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LabelPolicy restricts which processes may decrypt secrets carrying a given
+// label, on top of the label being granted to the caller's container. Every
+// non-empty field must be satisfied.
+//
+// The following restricts the db-prod label to /usr/bin/myapp started as the
+// container's init process, confined by SELinux as container_t:
+//  label_policies:
+//    db-prod:
+//      exe: [/usr/bin/myapp]
+//      container_init: true
+//      selinux_type: [container_t]
+type LabelPolicy struct {
+	// Exe lists the allowed executable paths of the caller.
+	Exe []string `yaml:"exe,omitempty"`
+	// ExeSHA256 lists the allowed hex-encoded SHA-256 digests of the caller's
+	// executable.
+	ExeSHA256 []string `yaml:"exe_sha256,omitempty"`
+	// ParentExe lists executable paths of which at least one must be an
+	// ancestor of the caller within its container.
+	ParentExe []string `yaml:"parent_exe,omitempty"`
+	// ContainerInit requires the caller to be PID 1 of its container.
+	ContainerInit bool `yaml:"container_init,omitempty"`
+
+	// SELinuxType lists the allowed SELinux types of the caller.
+	SELinuxType []string `yaml:"selinux_type,omitempty"`
+	// SELinuxLevel lists the allowed SELinux levels of the caller, such as the
+	// sVirt MCS categories "s0:c123,c456" of a single container.
+	SELinuxLevel []string `yaml:"selinux_level,omitempty"`
+	// AppArmorProfile lists the allowed AppArmor profiles of the caller.
+	AppArmorProfile []string `yaml:"apparmor_profile,omitempty"`
+}
+
+func (p *LabelPolicy) requiresProcess() bool {
+	return len(p.Exe) > 0 || len(p.ExeSHA256) > 0 || len(p.ParentExe) > 0 || p.ContainerInit
+}
+
+func (p *LabelPolicy) validate() error {
+	for i, sum := range p.ExeSHA256 {
+		sum = strings.ToLower(sum)
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+			return fmt.Errorf("invalid exe_sha256 %q", p.ExeSHA256[i])
+		}
+		p.ExeSHA256[i] = sum
+	}
+	return nil
+}
+
+// authorize returns an error describing the first requirement of the policy
+// that the peer of c does not satisfy.
+func (p *LabelPolicy) authorize(c *conn) error {
+	if err := p.authorizeSecurityContext(c.securityContext); err != nil {
+		return err
+	}
+	if !p.requiresProcess() {
+		return nil
+	}
+	proc, err := c.process()
+	if err != nil {
+		return fmt.Errorf("failed to inspect peer process: %v", err)
+	}
+	if len(p.Exe) > 0 && !contains(p.Exe, proc.Exe) {
+		return fmt.Errorf("executable %s is not allowed", proc.Exe)
+	}
+	if len(p.ExeSHA256) > 0 {
+		sum, err := proc.ExeSHA256()
+		if err != nil {
+			return fmt.Errorf("failed to hash executable %s: %v", proc.Exe, err)
+		}
+		if !contains(p.ExeSHA256, sum) {
+			return fmt.Errorf("executable %s with sha256 %s is not allowed", proc.Exe, sum)
+		}
+	}
+	if len(p.ParentExe) > 0 {
+		found := false
+		for _, parent := range proc.Parents {
+			if contains(p.ParentExe, parent) {
+				found = true
+				break
+			}
+		}
+		if !found && proc.ParentsErr != nil {
+			return proc.ParentsErr
+		}
+		if !found {
+			return fmt.Errorf("none of the parents %v of %s are allowed", proc.Parents, proc.Exe)
+		}
+	}
+	if p.ContainerInit && !proc.ContainerInit {
+		return fmt.Errorf("executable %s is not the container init process", proc.Exe)
+	}
+	return nil
+}
+
+func (p *LabelPolicy) authorizeSecurityContext(ctx *securityContext) error {
+	if len(p.SELinuxType) == 0 && len(p.SELinuxLevel) == 0 && len(p.AppArmorProfile) == 0 {
+		return nil
+	}
+	if ctx == nil {
+		return errors.New("peer has no security context")
+	}
+	if len(p.SELinuxType) > 0 && !contains(p.SELinuxType, ctx.SELinuxType) {
+		return fmt.Errorf("security context %s is not allowed", ctx.Raw)
+	}
+	if len(p.SELinuxLevel) > 0 && !contains(p.SELinuxLevel, ctx.SELinuxLevel) {
+		return fmt.Errorf("security context %s is not allowed", ctx.Raw)
+	}
+	if len(p.AppArmorProfile) > 0 && !contains(p.AppArmorProfile, ctx.AppArmorProfile) {
+		return fmt.Errorf("security context %s is not allowed", ctx.Raw)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package pal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestLabelPolicy(t *testing.T) {
+	// inspect a child so that the test binary is a known parent
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	c, err := newConn(nil, &syscall.Ucred{Pid: int32(cmd.Process.Pid)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.securityContext = parseSecurityContext("system_u:system_r:container_t:s0:c123,c456")
+	proc, err := c.process()
+	if err != nil {
+		t.Fatalf("failed to inspect child process: %v", err)
+	}
+	exe, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		t.Fatal(err)
+	}
+	if proc.Exe != exe {
+		t.Errorf("want exe %q, got %q", exe, proc.Exe)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proc.Parents) == 0 || proc.Parents[0] != self {
+		t.Fatalf("want %q as first parent, got %v", self, proc.Parents)
+	}
+	buf, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf)
+	exeSHA256 := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		policy LabelPolicy
+		err    string
+	}{
+		{policy: LabelPolicy{}},
+		{policy: LabelPolicy{Exe: []string{"/usr/bin/myapp", exe}}},
+		{policy: LabelPolicy{ExeSHA256: []string{strings.ToUpper(exeSHA256)}}},
+		{policy: LabelPolicy{ParentExe: []string{self}}},
+		{
+			policy: LabelPolicy{Exe: []string{"/usr/bin/myapp"}},
+			err:    "executable " + exe + " is not allowed",
+		},
+		{
+			policy: LabelPolicy{ExeSHA256: []string{strings.Repeat("0", 64)}},
+			err:    "executable " + exe + " with sha256 " + exeSHA256 + " is not allowed",
+		},
+		{
+			policy: LabelPolicy{ContainerInit: true},
+			err:    "executable " + exe + " is not the container init process",
+		},
+		{policy: LabelPolicy{SELinuxType: []string{"container_t"}, SELinuxLevel: []string{"s0:c123,c456"}}},
+		{
+			policy: LabelPolicy{SELinuxLevel: []string{"s0:c1,c2"}},
+			err:    "security context system_u:system_r:container_t:s0:c123,c456 is not allowed",
+		},
+		{
+			policy: LabelPolicy{AppArmorProfile: []string{"docker-default"}},
+			err:    "security context system_u:system_r:container_t:s0:c123,c456 is not allowed",
+		},
+	}
+
+	for _, test := range tests {
+		if err := test.policy.validate(); err != nil {
+			t.Errorf("policy %+v: %v", test.policy, err)
+			continue
+		}
+		err := test.policy.authorize(c)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("policy %+v: want no error, got %v", test.policy, err)
+		case test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)):
+			t.Errorf("policy %+v: want error %q, got %v", test.policy, test.err, err)
+		}
+	}
+
+	// the ancestry above the test binary may not be readable, so only check
+	// that the caller is denied
+	parent := LabelPolicy{ParentExe: []string{"/usr/bin/myapp"}}
+	if err := parent.authorize(c); err == nil {
+		t.Error("want error for disallowed parent")
+	}
+
+	invalid := LabelPolicy{ExeSHA256: []string{"abcd"}}
+	if err := invalid.validate(); err == nil {
+		t.Error("want error for truncated exe_sha256")
+	}
+}
+
+func TestLabelPolicyConcurrent(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	c, err := newConn(nil, &syscall.Ucred{Pid: int32(cmd.Process.Pid)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// requests pipelined on a connection authorize against the same process
+	policy := LabelPolicy{ExeSHA256: []string{strings.Repeat("0", 64)}}
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = policy.authorize(c)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err == nil || err.Error() != errs[0].Error() {
+			t.Errorf("want the same denial for every request, got %v and %v", errs[0], err)
+		}
+	}
+}
+
+func TestParseSecurityContext(t *testing.T) {
+	tests := map[string]securityContext{
+		"system_u:system_r:svirt_lxc_net_t:s0:c1,c2": {
+			SELinuxUser:  "system_u",
+			SELinuxRole:  "system_r",
+			SELinuxType:  "svirt_lxc_net_t",
+			SELinuxLevel: "s0:c1,c2",
+		},
+		"docker-default (enforce)": {AppArmorProfile: "docker-default"},
+		"unconfined":               {AppArmorProfile: "unconfined"},
+		"garbage":                  {},
+	}
+	for raw, want := range tests {
+		want.Raw = raw
+		if got := parseSecurityContext(raw); *got != want {
+			t.Errorf("want %+v for %q, got %+v", want, raw, *got)
+		}
+	}
+}
+
+func TestSecurityContextRetriever(t *testing.T) {
+	buf, err := ioutil.ReadFile(procPath(os.Getpid(), "attr/current"))
+	if err != nil {
+		t.Skipf("no security context: %v", err)
+	}
+	raw := strings.TrimRight(string(buf), "\x00\n")
+	labels := make(map[string][]string)
+	keys := parseSecurityContext(raw).keys()
+	for _, key := range keys {
+		labels[key] = []string{"confined"}
+	}
+	r := &securityContextRetriever{labels: labels}
+	identity, err := r.IdentityForPID(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Attributes["security_context"] != raw {
+		t.Errorf("want security context %q, got %q", raw, identity.Attributes["security_context"])
+	}
+	if got, want := identity.HasLabel("confined"), raw != "" && len(keys) > 0; got != want {
+		t.Errorf("want label granted %v for %q, got %v", want, raw, got)
+	}
+	if _, err := r.IdentityForPID(-1); err == nil {
+		t.Error("want an error for a missing process")
+	}
+}
@@ -0,0 +1,124 @@
+package pal
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/pal/log"
+)
+
+// workerPool runs jobs on a fixed number of goroutines. Submitting a job blocks
+// while all of them are busy, so that a connection stops reading requests and
+// its client is pushed back through the socket buffers.
+type workerPool struct {
+	jobs chan func()
+	done chan struct{}
+	now  func() time.Time
+
+	mu sync.Mutex
+	// waiting is the number of jobs waiting for a worker, of which there has
+	// been at least one since waitingSince.
+	waiting      int
+	waitingSince time.Time
+	// lastDone is when the last job completed, or when the pool started.
+	lastDone time.Time
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{
+		jobs:     make(chan func()),
+		done:     make(chan struct{}),
+		now:      time.Now,
+		lastDone: time.Now(),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case job := <-p.jobs:
+			job()
+			p.mu.Lock()
+			p.lastDone = p.now()
+			p.mu.Unlock()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// submit waits for a worker to run job. It returns false if the pool was
+// stopped first.
+func (p *workerPool) submit(job func()) bool {
+	return p.trySubmit(job, nil)
+}
+
+// trySubmit is like submit, but also gives up when cancel is closed.
+func (p *workerPool) trySubmit(job func(), cancel <-chan struct{}) bool {
+	p.mu.Lock()
+	if p.waiting == 0 {
+		p.waitingSince = p.now()
+	}
+	p.waiting++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+	}()
+
+	select {
+	case p.jobs <- job:
+		return true
+	case <-p.done:
+		return false
+	case <-cancel:
+		return false
+	}
+}
+
+// stalled reports whether jobs have been waiting for a worker for longer than
+// window while no job completed, i.e. whether the workers are stuck rather
+// than busy.
+func (p *workerPool) stalled(window time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	return p.waiting > 0 && now.Sub(p.waitingSince) > window && now.Sub(p.lastDone) > window
+}
+
+// stop stops the workers once they are done with their current job.
+func (p *workerPool) stop() {
+	close(p.done)
+}
+
+// responseEncoder serializes the responses written to a connection by the
+// workers serving its requests. If conn is set, each response must be written
+// within timeout.
+type responseEncoder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (e *responseEncoder) Encode(v interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.SetWriteDeadline(time.Now().Add(e.timeout))
+	}
+	return e.encoder.Encode(v)
+}
+
+func (e *responseEncoder) write(resp *decryptionResponse) {
+	if err := e.Encode(resp); err != nil {
+		log.Errorf("Failed to marshal decryption response: %v", err)
+	}
+}
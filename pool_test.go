@@ -0,0 +1,64 @@
+package pal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolStalled(t *testing.T) {
+	pool := newWorkerPool(2)
+	defer pool.stop()
+	now := time.Unix(0, 0)
+	pool.mu.Lock()
+	pool.now = func() time.Time { return now }
+	pool.lastDone = now
+	pool.mu.Unlock()
+
+	// keep both workers busy, and a third job waiting for one of them
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		go pool.submit(func() {
+			started <- struct{}{}
+			<-release
+		})
+	}
+	<-started
+	<-started
+	for {
+		pool.mu.Lock()
+		waiting := pool.waiting
+		pool.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if pool.stalled(time.Second) {
+		t.Error("want a busy pool not to be stalled before the window elapses")
+	}
+	pool.mu.Lock()
+	now = now.Add(2 * time.Second)
+	pool.mu.Unlock()
+	if !pool.stalled(time.Second) {
+		t.Error("want a pool stalled once no job completed in the window")
+	}
+
+	// a job completing shows the workers are busy rather than stuck
+	release <- struct{}{}
+	<-started
+	for {
+		pool.mu.Lock()
+		done := pool.lastDone.Equal(now)
+		pool.mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if pool.stalled(time.Second) {
+		t.Error("want a busy pool completing jobs not to be stalled")
+	}
+	close(release)
+}
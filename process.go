@@ -0,0 +1,149 @@
+package pal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxProcessAncestors bounds the walk up the process tree of a peer.
+const maxProcessAncestors = 32
+
+// processInfo describes the executable and the ancestry of a peer process.
+type processInfo struct {
+	pid int
+	// Exe is the path of the executable as seen from within the process' mount
+	// namespace.
+	Exe string
+	// Parents holds the executable paths of the ancestors of the process,
+	// nearest first, up to and including the init process of its PID
+	// namespace.
+	Parents []string
+	// ContainerInit reports whether the process is PID 1 of its PID namespace,
+	// i.e. the init process of its container.
+	ContainerInit bool
+	// ParentsErr is set if the ancestry of the process could not be fully
+	// determined, in which case Parents is incomplete.
+	ParentsErr error
+
+	// the digest of the executable is computed once, as the processInfo of
+	// a connection is shared by the requests served concurrently on it
+	exeOnce   sync.Once
+	exeSHA256 string
+	exeErr    error
+}
+
+// readProcessInfo inspects /proc to describe pid.
+func readProcessInfo(pid int) (*processInfo, error) {
+	exe, err := os.Readlink(procPath(pid, "exe"))
+	if err != nil {
+		return nil, err
+	}
+	status, err := readProcStatus(pid)
+	if err != nil {
+		return nil, err
+	}
+	p := &processInfo{
+		pid:           pid,
+		Exe:           exe,
+		ContainerInit: status.nsInit,
+	}
+
+	ppid := status.ppid
+	for i := 0; !status.nsInit && ppid > 0 && i < maxProcessAncestors; i++ {
+		parentExe, err := os.Readlink(procPath(ppid, "exe"))
+		if err == nil {
+			status, err = readProcStatus(ppid)
+		}
+		if err != nil {
+			p.ParentsErr = fmt.Errorf("failed to inspect parent %d of %d: %v", ppid, pid, err)
+			break
+		}
+		p.Parents = append(p.Parents, parentExe)
+		ppid = status.ppid
+	}
+	return p, nil
+}
+
+// ExeSHA256 returns the hex-encoded SHA-256 digest of the executable of the
+// process. It is computed on first use.
+func (p *processInfo) ExeSHA256() (string, error) {
+	p.exeOnce.Do(func() {
+		p.exeSHA256, p.exeErr = hashExe(p.pid)
+	})
+	return p.exeSHA256, p.exeErr
+}
+
+func hashExe(pid int) (string, error) {
+	// Opening /proc/<pid>/exe yields the very file being executed, even if the
+	// path has since been replaced or lives in another mount namespace.
+	f, err := os.Open(procPath(pid, "exe"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type procStatus struct {
+	ppid int
+	// nsInit is true if the process is PID 1 in its own PID namespace.
+	nsInit bool
+}
+
+func readProcStatus(pid int) (*procStatus, error) {
+	f, err := os.Open(procPath(pid, "status"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	status := new(procStatus)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "PPid:":
+			if status.ppid, err = strconv.Atoi(fields[1]); err != nil {
+				return nil, err
+			}
+		case "NSpid:":
+			// the last field is the PID in the innermost namespace
+			status.nsInit = fields[len(fields)-1] == "1"
+		}
+	}
+	return status, scanner.Err()
+}
+
+func procPath(pid int, name string) string {
+	return "/proc/" + strconv.Itoa(pid) + "/" + name
+}
+
+// containerIDRegexp matches the 64 hex digit ID of a container in a cgroup
+// path, such as /docker/<id> or /kubepods/.../docker-<id>.scope.
+var containerIDRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// procContainerID returns the ID of the container of pid according to its
+// cgroups, or "" if it does not seem to run in a container.
+func procContainerID(pid int) (string, error) {
+	buf, err := ioutil.ReadFile(procPath(pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	return containerIDRegexp.FindString(string(buf)), nil
+}
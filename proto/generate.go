@@ -0,0 +1,3 @@
+package palpb
+
+//go:generate protoc --go_out=plugins=grpc:. pald.proto
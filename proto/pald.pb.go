@@ -0,0 +1,478 @@
+// Code generated by protoc-gen-go.
+// source: pald.proto
+// DO NOT EDIT!
+
+/*
+Package palpb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	pald.proto
+
+It has these top-level messages:
+
+	DecryptRequest
+	DecryptResponse
+	Error
+	WhoAmIRequest
+	WhoAmIResponse
+	HealthRequest
+	HealthResponse
+*/
+package palpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type HealthResponse_Status int32
+
+const (
+	HealthResponse_UNKNOWN     HealthResponse_Status = 0
+	HealthResponse_SERVING     HealthResponse_Status = 1
+	HealthResponse_NOT_SERVING HealthResponse_Status = 2
+)
+
+var HealthResponse_Status_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+}
+var HealthResponse_Status_value = map[string]int32{
+	"UNKNOWN":     0,
+	"SERVING":     1,
+	"NOT_SERVING": 2,
+}
+
+func (x HealthResponse_Status) String() string {
+	return proto.EnumName(HealthResponse_Status_name, int32(x))
+}
+func (HealthResponse_Status) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{6, 0} }
+
+type DecryptRequest struct {
+	// id is echoed in the response.
+	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	// ciphertexts maps names (environment variables or file paths) to PAL
+	// values such as "pgp:..." or "ro+base64:...".
+	Ciphertexts map[string]string `protobuf:"bytes,2,rep,name=ciphertexts" json:"ciphertexts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *DecryptRequest) Reset()                    { *m = DecryptRequest{} }
+func (m *DecryptRequest) String() string            { return proto.CompactTextString(m) }
+func (*DecryptRequest) ProtoMessage()               {}
+func (*DecryptRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *DecryptRequest) GetCiphertexts() map[string]string {
+	if m != nil {
+		return m.Ciphertexts
+	}
+	return nil
+}
+
+type DecryptResponse struct {
+	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	// secrets maps the names of the request to their plaintexts, prefixed with
+	// "base64:" for base64 values.
+	Secrets map[string]string `protobuf:"bytes,2,rep,name=secrets" json:"secrets,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Error   *Error            `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *DecryptResponse) Reset()                    { *m = DecryptResponse{} }
+func (m *DecryptResponse) String() string            { return proto.CompactTextString(m) }
+func (*DecryptResponse) ProtoMessage()               {}
+func (*DecryptResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *DecryptResponse) GetSecrets() map[string]string {
+	if m != nil {
+		return m.Secrets
+	}
+	return nil
+}
+
+func (m *DecryptResponse) GetError() *Error {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+type Error struct {
+	Code    int32  `protobuf:"varint,1,opt,name=code" json:"code,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	// secret is the name of the secret that caused the error, if any.
+	Secret string `protobuf:"bytes,3,opt,name=secret" json:"secret,omitempty"`
+}
+
+func (m *Error) Reset()                    { *m = Error{} }
+func (m *Error) String() string            { return proto.CompactTextString(m) }
+func (*Error) ProtoMessage()               {}
+func (*Error) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+type WhoAmIRequest struct {
+}
+
+func (m *WhoAmIRequest) Reset()                    { *m = WhoAmIRequest{} }
+func (m *WhoAmIRequest) String() string            { return proto.CompactTextString(m) }
+func (*WhoAmIRequest) ProtoMessage()               {}
+func (*WhoAmIRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+type WhoAmIResponse struct {
+	Uid             uint32            `protobuf:"varint,1,opt,name=uid" json:"uid,omitempty"`
+	Gid             uint32            `protobuf:"varint,2,opt,name=gid" json:"gid,omitempty"`
+	Pid             int32             `protobuf:"varint,3,opt,name=pid" json:"pid,omitempty"`
+	ContainerId     string            `protobuf:"bytes,4,opt,name=container_id,json=containerId" json:"container_id,omitempty"`
+	ImageName       string            `protobuf:"bytes,5,opt,name=image_name,json=imageName" json:"image_name,omitempty"`
+	ImageDigest     string            `protobuf:"bytes,6,opt,name=image_digest,json=imageDigest" json:"image_digest,omitempty"`
+	SignerRole      string            `protobuf:"bytes,7,opt,name=signer_role,json=signerRole" json:"signer_role,omitempty"`
+	Pod             string            `protobuf:"bytes,8,opt,name=pod" json:"pod,omitempty"`
+	PodNamespace    string            `protobuf:"bytes,9,opt,name=pod_namespace,json=podNamespace" json:"pod_namespace,omitempty"`
+	Labels          []string          `protobuf:"bytes,10,rep,name=labels" json:"labels,omitempty"`
+	Attributes      map[string]string `protobuf:"bytes,11,rep,name=attributes" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	SecurityContext string            `protobuf:"bytes,12,opt,name=security_context,json=securityContext" json:"security_context,omitempty"`
+	Certificate     string            `protobuf:"bytes,13,opt,name=certificate" json:"certificate,omitempty"`
+	// trusted reports whether image_digest was verified against its signature.
+	Trusted       bool `protobuf:"varint,14,opt,name=trusted" json:"trusted,omitempty"`
+	LabelsEnabled bool `protobuf:"varint,15,opt,name=labels_enabled,json=labelsEnabled" json:"labels_enabled,omitempty"`
+	// sources explains which sources of a composite retriever granted each
+	// label.
+	Sources map[string]string `protobuf:"bytes,16,rep,name=sources" json:"sources,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// errors are the errors of the labels retriever, or of its sources.
+	Errors []string `protobuf:"bytes,17,rep,name=errors" json:"errors,omitempty"`
+}
+
+func (m *WhoAmIResponse) Reset()                    { *m = WhoAmIResponse{} }
+func (m *WhoAmIResponse) String() string            { return proto.CompactTextString(m) }
+func (*WhoAmIResponse) ProtoMessage()               {}
+func (*WhoAmIResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *WhoAmIResponse) GetAttributes() map[string]string {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
+func (m *WhoAmIResponse) GetSources() map[string]string {
+	if m != nil {
+		return m.Sources
+	}
+	return nil
+}
+
+type HealthRequest struct {
+}
+
+func (m *HealthRequest) Reset()                    { *m = HealthRequest{} }
+func (m *HealthRequest) String() string            { return proto.CompactTextString(m) }
+func (*HealthRequest) ProtoMessage()               {}
+func (*HealthRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type HealthResponse struct {
+	Status HealthResponse_Status `protobuf:"varint,1,opt,name=status,enum=pal.v1.HealthResponse_Status" json:"status,omitempty"`
+}
+
+func (m *HealthResponse) Reset()                    { *m = HealthResponse{} }
+func (m *HealthResponse) String() string            { return proto.CompactTextString(m) }
+func (*HealthResponse) ProtoMessage()               {}
+func (*HealthResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func init() {
+	proto.RegisterType((*DecryptRequest)(nil), "pal.v1.DecryptRequest")
+	proto.RegisterType((*DecryptResponse)(nil), "pal.v1.DecryptResponse")
+	proto.RegisterType((*Error)(nil), "pal.v1.Error")
+	proto.RegisterType((*WhoAmIRequest)(nil), "pal.v1.WhoAmIRequest")
+	proto.RegisterType((*WhoAmIResponse)(nil), "pal.v1.WhoAmIResponse")
+	proto.RegisterType((*HealthRequest)(nil), "pal.v1.HealthRequest")
+	proto.RegisterType((*HealthResponse)(nil), "pal.v1.HealthResponse")
+	proto.RegisterEnum("pal.v1.HealthResponse_Status", HealthResponse_Status_name, HealthResponse_Status_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Pald service
+
+type PaldClient interface {
+	// Decrypt decrypts the given ciphertexts if the caller is authorized to
+	// obtain all of them. A deadline set by the client bounds the time it waits
+	// for the answer.
+	Decrypt(ctx context.Context, in *DecryptRequest, opts ...grpc.CallOption) (*DecryptResponse, error)
+	// DecryptStream decrypts each request as it arrives, so that a client can
+	// pipeline requests on a single stream.
+	DecryptStream(ctx context.Context, opts ...grpc.CallOption) (Pald_DecryptStreamClient, error)
+	// WhoAmI returns the identity pald attributes to the caller, like the
+	// whoami requests of the JSON protocol.
+	WhoAmI(ctx context.Context, in *WhoAmIRequest, opts ...grpc.CallOption) (*WhoAmIResponse, error)
+	// Health reports whether pald is ready to serve requests.
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
+}
+
+type paldClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewPaldClient(cc *grpc.ClientConn) PaldClient {
+	return &paldClient{cc}
+}
+
+func (c *paldClient) Decrypt(ctx context.Context, in *DecryptRequest, opts ...grpc.CallOption) (*DecryptResponse, error) {
+	out := new(DecryptResponse)
+	err := grpc.Invoke(ctx, "/pal.v1.Pald/Decrypt", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paldClient) DecryptStream(ctx context.Context, opts ...grpc.CallOption) (Pald_DecryptStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Pald_serviceDesc.Streams[0], c.cc, "/pal.v1.Pald/DecryptStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &paldDecryptStreamClient{stream}
+	return x, nil
+}
+
+type Pald_DecryptStreamClient interface {
+	Send(*DecryptRequest) error
+	Recv() (*DecryptResponse, error)
+	grpc.ClientStream
+}
+
+type paldDecryptStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *paldDecryptStreamClient) Send(m *DecryptRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *paldDecryptStreamClient) Recv() (*DecryptResponse, error) {
+	m := new(DecryptResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *paldClient) WhoAmI(ctx context.Context, in *WhoAmIRequest, opts ...grpc.CallOption) (*WhoAmIResponse, error) {
+	out := new(WhoAmIResponse)
+	err := grpc.Invoke(ctx, "/pal.v1.Pald/WhoAmI", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *paldClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	out := new(HealthResponse)
+	err := grpc.Invoke(ctx, "/pal.v1.Pald/Health", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Pald service
+
+type PaldServer interface {
+	// Decrypt decrypts the given ciphertexts if the caller is authorized to
+	// obtain all of them. A deadline set by the client bounds the time it waits
+	// for the answer.
+	Decrypt(context.Context, *DecryptRequest) (*DecryptResponse, error)
+	// DecryptStream decrypts each request as it arrives, so that a client can
+	// pipeline requests on a single stream.
+	DecryptStream(Pald_DecryptStreamServer) error
+	// WhoAmI returns the identity pald attributes to the caller, like the
+	// whoami requests of the JSON protocol.
+	WhoAmI(context.Context, *WhoAmIRequest) (*WhoAmIResponse, error)
+	// Health reports whether pald is ready to serve requests.
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+}
+
+func RegisterPaldServer(s *grpc.Server, srv PaldServer) {
+	s.RegisterService(&_Pald_serviceDesc, srv)
+}
+
+func _Pald_Decrypt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecryptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaldServer).Decrypt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pal.v1.Pald/Decrypt",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaldServer).Decrypt(ctx, req.(*DecryptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pald_DecryptStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PaldServer).DecryptStream(&paldDecryptStreamServer{stream})
+}
+
+type Pald_DecryptStreamServer interface {
+	Send(*DecryptResponse) error
+	Recv() (*DecryptRequest, error)
+	grpc.ServerStream
+}
+
+type paldDecryptStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *paldDecryptStreamServer) Send(m *DecryptResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *paldDecryptStreamServer) Recv() (*DecryptRequest, error) {
+	m := new(DecryptRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Pald_WhoAmI_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WhoAmIRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaldServer).WhoAmI(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pal.v1.Pald/WhoAmI",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaldServer).WhoAmI(ctx, req.(*WhoAmIRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pald_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaldServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pal.v1.Pald/Health",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaldServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Pald_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pal.v1.Pald",
+	HandlerType: (*PaldServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Decrypt",
+			Handler:    _Pald_Decrypt_Handler,
+		},
+		{
+			MethodName: "WhoAmI",
+			Handler:    _Pald_WhoAmI_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _Pald_Health_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "DecryptStream",
+			Handler:       _Pald_DecryptStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pald.proto",
+}
+
+func init() { proto.RegisterFile("pald.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 760 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0xdf, 0x8f, 0x22, 0x45,
+	0x10, 0x76, 0xf8, 0x79, 0xd4, 0x30, 0x80, 0x1d, 0xc5, 0x0e, 0xc9, 0x45, 0x64, 0x3d, 0x0f, 0x5f,
+	0x66, 0x95, 0x8b, 0xd1, 0xac, 0xb9, 0x4b, 0xce, 0x5b, 0x54, 0x62, 0xe4, 0xcc, 0xa0, 0x6e, 0xe2,
+	0x0b, 0x69, 0xa6, 0x6b, 0x61, 0xe2, 0x40, 0x8f, 0xdd, 0x3d, 0x9b, 0x25, 0xf1, 0x3f, 0xf2, 0xbf,
+	0xd0, 0xff, 0xca, 0xa7, 0xcb, 0x74, 0xcf, 0xb0, 0xc0, 0xee, 0x3e, 0xf0, 0xd6, 0xf5, 0xcd, 0xf7,
+	0x55, 0x55, 0x7f, 0x55, 0x34, 0x00, 0x09, 0x8b, 0xb9, 0x9f, 0x48, 0xa1, 0x05, 0xa9, 0x25, 0x2c,
+	0xf6, 0x6f, 0xbe, 0x1c, 0xfc, 0xe3, 0x40, 0xeb, 0x12, 0x43, 0xb9, 0x4d, 0x74, 0x80, 0x7f, 0xa5,
+	0xa8, 0x34, 0x69, 0x41, 0x29, 0xe2, 0xd4, 0xe9, 0x3b, 0xc3, 0x4a, 0x50, 0x8a, 0x38, 0x99, 0x80,
+	0x1b, 0x46, 0xc9, 0x0a, 0xa5, 0xc6, 0x5b, 0xad, 0x68, 0xa9, 0x5f, 0x1e, 0xba, 0xa3, 0xe7, 0xbe,
+	0x4d, 0xe0, 0x1f, 0x8a, 0xfd, 0x37, 0x77, 0xcc, 0xf1, 0x46, 0xcb, 0x6d, 0xb0, 0xaf, 0xed, 0xbd,
+	0x82, 0xce, 0x31, 0x81, 0x74, 0xa0, 0xfc, 0x27, 0x6e, 0x4d, 0xbd, 0x46, 0x90, 0x1d, 0xc9, 0x07,
+	0x50, 0xbd, 0x61, 0x71, 0x8a, 0xb4, 0x64, 0x30, 0x1b, 0x5c, 0x94, 0xbe, 0x71, 0x06, 0xff, 0x3a,
+	0xd0, 0xde, 0x15, 0x54, 0x89, 0xd8, 0x28, 0xbc, 0xd7, 0xee, 0x2b, 0xa8, 0x2b, 0x0c, 0x25, 0xee,
+	0x5a, 0xfd, 0xf4, 0x5e, 0xab, 0x56, 0xe9, 0xcf, 0x2c, 0xcd, 0xf6, 0x59, 0x88, 0xc8, 0x19, 0x54,
+	0x51, 0x4a, 0x21, 0x69, 0xb9, 0xef, 0x0c, 0xdd, 0x91, 0x57, 0xa8, 0xc7, 0x19, 0x18, 0xd8, 0x6f,
+	0xbd, 0x0b, 0x68, 0xee, 0xab, 0x4f, 0xba, 0xc4, 0xcf, 0x50, 0x35, 0xb9, 0x08, 0x81, 0x4a, 0x28,
+	0x38, 0x1a, 0x55, 0x35, 0x30, 0x67, 0x42, 0xa1, 0xbe, 0x46, 0xa5, 0xd8, 0xb2, 0x10, 0x16, 0x21,
+	0xe9, 0x42, 0xcd, 0xb6, 0x68, 0x1a, 0x6b, 0x04, 0x79, 0x34, 0x68, 0x83, 0x77, 0xb5, 0x12, 0xaf,
+	0xd7, 0x93, 0x7c, 0x04, 0x83, 0xff, 0xaa, 0xd0, 0x2a, 0x90, 0xdc, 0xa3, 0x0e, 0x94, 0xd3, 0xdc,
+	0x24, 0x2f, 0xc8, 0x8e, 0x19, 0xb2, 0x8c, 0xb8, 0xa9, 0xe1, 0x05, 0xe5, 0xa5, 0x45, 0x92, 0x88,
+	0x9b, 0xe4, 0xd5, 0x20, 0x3b, 0x92, 0x4f, 0xa0, 0x19, 0x8a, 0x8d, 0x66, 0xd1, 0x06, 0xe5, 0x3c,
+	0xe2, 0xb4, 0x62, 0xea, 0xba, 0x3b, 0x6c, 0xc2, 0xc9, 0x53, 0x80, 0x68, 0xcd, 0x96, 0x38, 0xdf,
+	0xb0, 0x35, 0xd2, 0xaa, 0x21, 0x34, 0x0c, 0x32, 0x65, 0x6b, 0xcc, 0x32, 0xd8, 0xcf, 0x3c, 0x5a,
+	0xa2, 0xd2, 0xb4, 0x66, 0x33, 0x18, 0xec, 0xd2, 0x40, 0xe4, 0x63, 0x70, 0x55, 0xb4, 0xcc, 0x2a,
+	0x48, 0x11, 0x23, 0xad, 0x1b, 0x06, 0x58, 0x28, 0x10, 0xb1, 0xe9, 0x3d, 0x11, 0x9c, 0x3e, 0xb1,
+	0xd6, 0x26, 0x82, 0x93, 0x33, 0xf0, 0x12, 0xc1, 0x4d, 0x49, 0x95, 0xb0, 0x10, 0x69, 0xc3, 0x7c,
+	0x6b, 0x26, 0x82, 0x4f, 0x0b, 0x2c, 0xb3, 0x2b, 0x66, 0x0b, 0x8c, 0x15, 0x85, 0x7e, 0x39, 0xb3,
+	0xcb, 0x46, 0xe4, 0x7b, 0x00, 0xa6, 0xb5, 0x8c, 0x16, 0xa9, 0x46, 0x45, 0x5d, 0xb3, 0x21, 0x9f,
+	0x15, 0x33, 0x3e, 0xb4, 0xcd, 0x7f, 0xbd, 0x23, 0xda, 0x1d, 0xd9, 0x53, 0x92, 0xcf, 0xa1, 0xa3,
+	0x30, 0x4c, 0x65, 0xa4, 0xb7, 0xf3, 0xcc, 0x11, 0xbc, 0xd5, 0xb4, 0x69, 0xfa, 0x68, 0x17, 0xf8,
+	0x1b, 0x0b, 0x93, 0x3e, 0xb8, 0x21, 0x4a, 0x1d, 0x5d, 0x47, 0x21, 0xd3, 0x48, 0xbd, 0xdc, 0xc6,
+	0x3b, 0x28, 0x9b, 0xba, 0x96, 0xa9, 0xd2, 0xc8, 0x69, 0xab, 0xef, 0x0c, 0x9f, 0x04, 0x45, 0x48,
+	0x9e, 0x41, 0xcb, 0x36, 0x3e, 0xc7, 0x0d, 0x5b, 0xc4, 0xc8, 0x69, 0xdb, 0x10, 0x3c, 0x8b, 0x8e,
+	0x2d, 0x48, 0x5e, 0x42, 0x5d, 0x89, 0x54, 0x86, 0xa8, 0x68, 0xc7, 0x5c, 0xe9, 0xec, 0x91, 0x2b,
+	0xcd, 0x2c, 0xab, 0xd8, 0x79, 0x1b, 0x65, 0x66, 0x99, 0xbd, 0x56, 0xf4, 0x7d, 0x6b, 0x96, 0x8d,
+	0x7a, 0x2f, 0xa1, 0x7d, 0xe4, 0xc1, 0x29, 0x9b, 0x6e, 0x7e, 0x25, 0x7b, 0xf5, 0x4e, 0xfa, 0x95,
+	0xb4, 0xc1, 0xfb, 0x11, 0x59, 0xac, 0x57, 0xc5, 0x5a, 0xff, 0x0d, 0xad, 0x02, 0xc8, 0xb7, 0xfa,
+	0x2b, 0xa8, 0x29, 0xcd, 0x74, 0xaa, 0x4c, 0xc6, 0xd6, 0xe8, 0x69, 0x71, 0xe7, 0x43, 0x9e, 0x3f,
+	0x33, 0xa4, 0x20, 0x27, 0x0f, 0x5e, 0x40, 0xcd, 0x22, 0xc4, 0x85, 0xfa, 0x6f, 0xd3, 0x9f, 0xa6,
+	0x6f, 0xaf, 0xa6, 0x9d, 0xf7, 0xb2, 0x60, 0x36, 0x0e, 0x7e, 0x9f, 0x4c, 0x7f, 0xe8, 0x38, 0xa4,
+	0x0d, 0xee, 0xf4, 0xed, 0xaf, 0xf3, 0x02, 0x28, 0x8d, 0xfe, 0x77, 0xa0, 0xf2, 0x0b, 0x8b, 0x39,
+	0xb9, 0x80, 0x7a, 0xfe, 0x8e, 0x90, 0xee, 0xc3, 0x6f, 0x60, 0xef, 0xa3, 0x47, 0x1e, 0x1c, 0x72,
+	0x09, 0x5e, 0x0e, 0xcd, 0xb4, 0x44, 0xb6, 0x3e, 0x39, 0xc3, 0xd0, 0xf9, 0xc2, 0x21, 0x5f, 0x43,
+	0xcd, 0x0e, 0x95, 0x7c, 0x78, 0x3c, 0x64, 0xab, 0xee, 0x3e, 0x3c, 0xfb, 0x4c, 0x68, 0x9d, 0xb9,
+	0x13, 0x1e, 0x58, 0xdc, 0xeb, 0x1e, 0xc3, 0x56, 0xf8, 0xdd, 0xf3, 0x3f, 0x9e, 0x2d, 0x23, 0xbd,
+	0x4a, 0x17, 0x7e, 0x28, 0xd6, 0xe7, 0x61, 0x2c, 0x52, 0x7e, 0x1d, 0x33, 0x89, 0xe7, 0x09, 0x8b,
+	0xcf, 0xcd, 0xff, 0xc9, 0xb7, 0x09, 0x8b, 0x93, 0xc5, 0xa2, 0x66, 0x82, 0x17, 0xef, 0x06, 0x00,
+	0x0f, 0x79, 0x05, 0x2e, 0x6a, 0x06, 0x00, 0x00,
+}
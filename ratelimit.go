@@ -0,0 +1,118 @@
+package pal
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig configures a token bucket: a caller may decrypt Rate secrets
+// per second on average, in bursts of up to Burst secrets.
+type RateLimitConfig struct {
+	Rate float64 `yaml:"rate"`
+	// Burst defaults to Rate rounded up.
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// RateLimitsConfig configures the rate of decryptions allowed for each
+// container, each image and each label. A nil limit is unlimited. The images
+// of the callers are only known to the labels retriever, so Image requires
+// labels to be enabled. Remote callers are limited by Container for each
+// certificate identity. Only the secrets returned count against the limits.
+type RateLimitsConfig struct {
+	Container *RateLimitConfig `yaml:"container,omitempty"`
+	Image     *RateLimitConfig `yaml:"image,omitempty"`
+	Label     *RateLimitConfig `yaml:"label,omitempty"`
+}
+
+// maxIdleBuckets is the number of buckets a rateLimiter holds before it
+// forgets those that are full again, such as the buckets of stopped containers.
+const maxIdleBuckets = 4096
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds a token bucket for each key it limits.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// newRateLimiter returns the rateLimiter of config, or nil if config is nil.
+func newRateLimiter(config *RateLimitConfig) (*rateLimiter, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.Rate <= 0 {
+		return nil, fmt.Errorf("invalid rate limit: rate must be positive, got %v", config.Rate)
+	}
+	burst := float64(config.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(config.Rate)
+	}
+	return &rateLimiter{
+		rate:    config.Rate,
+		burst:   burst,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}, nil
+}
+
+// allow takes n tokens from the bucket of key and reports whether there were
+// enough of them. A request of more tokens than the burst is allowed once the
+// bucket is full, and leaves the bucket in debt. A nil rateLimiter allows
+// everything.
+func (l *rateLimiter) allow(key string, n int) bool {
+	if l == nil {
+		return true
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.forgetFull(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < math.Min(float64(n), l.burst) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// refund gives n tokens back to the bucket of key, for decryptions which were
+// allowed but not returned.
+func (l *rateLimiter) refund(key string, n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = math.Min(l.burst, b.tokens+float64(n))
+	}
+}
+
+// forgetFull removes the buckets that are full at now, since a new bucket would
+// behave the same.
+func (l *rateLimiter) forgetFull(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
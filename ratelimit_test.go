@@ -0,0 +1,105 @@
+package pal
+
+import (
+	"crypto/x509"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/pal/trustedlabels"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if _, err := newRateLimiter(&RateLimitConfig{}); err == nil {
+		t.Error("want an error for a zero rate")
+	}
+	var nilLimiter *rateLimiter
+	if !nilLimiter.allow("foo", 100) {
+		t.Error("want a nil limiter to allow everything")
+	}
+
+	l, err := newRateLimiter(&RateLimitConfig{Rate: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		elapsed time.Duration
+		key     string
+		n       int
+		want    bool
+	}{
+		{0, "foo", 2, true},
+		{0, "foo", 1, false},
+		{0, "bar", 1, true},
+		{250 * time.Millisecond, "foo", 1, false},
+		{250 * time.Millisecond, "foo", 1, true},
+		// the bucket holds at most the burst, 2 by default
+		{10 * time.Second, "foo", 2, true},
+		{0, "foo", 1, false},
+		// more secrets than the burst pass once the bucket is full, and
+		// leave it in debt
+		{10 * time.Second, "foo", 3, true},
+		{0, "foo", 3, false},
+		{time.Second, "foo", 1, true},
+		{0, "foo", 1, false},
+	}
+	for i, test := range tests {
+		now = now.Add(test.elapsed)
+		if got := l.allow(test.key, test.n); got != test.want {
+			t.Errorf("%d: allow(%s, %d) = %v, want %v", i, test.key, test.n, got, test.want)
+		}
+	}
+
+	// refunds fill the bucket up to the burst
+	now = now.Add(10 * time.Second)
+	l.allow("foo", 2)
+	l.refund("foo", 5)
+	if !l.allow("foo", 2) || l.allow("foo", 1) {
+		t.Error("want the refund to fill the bucket of foo up to the burst")
+	}
+	nilLimiter.refund("foo", 1)
+
+	// full buckets are forgotten once there are too many of them
+	l.buckets = make(map[string]*bucket)
+	for i := 0; i < maxIdleBuckets; i++ {
+		l.allow(fmt.Sprint(i), 1)
+	}
+	now = now.Add(time.Second)
+	l.allow("foo", 1)
+	if len(l.buckets) != 1 {
+		t.Errorf("want only the bucket of foo left, got %d buckets", len(l.buckets))
+	}
+}
+
+func TestRateLimitIdentity(t *testing.T) {
+	var err error
+	s := &Server{}
+	s.containerRate, err = newRateLimiter(&RateLimitConfig{Rate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.containerRate.now = func() time.Time { return time.Unix(0, 0) }
+
+	// remote callers are limited by certificate identity
+	c := &conn{cert: &x509.Certificate{Raw: []byte("cert")}}
+	identity := &trustedlabels.Identity{CertificateID: "spiffe://example.org/app"}
+	refund, _, err := s.rateLimitIdentity(c, identity, 1)
+	if err != nil {
+		t.Fatalf("want the first request allowed, got %v", err)
+	}
+	if _, reason, err := s.rateLimitIdentity(c, identity, 1); reason != rejectContainerRate || err == nil ||
+		err.Error() != "rate limit exceeded for certificate spiffe://example.org/app" {
+		t.Errorf("want the certificate rate limit exceeded, got %q, %v", reason, err)
+	}
+	other := &trustedlabels.Identity{CertificateID: "spiffe://example.org/other"}
+	if _, _, err := s.rateLimitIdentity(c, other, 1); err != nil {
+		t.Errorf("want another certificate allowed, got %v", err)
+	}
+	refund()
+	if _, _, err := s.rateLimitIdentity(c, identity, 1); err != nil {
+		t.Errorf("want the request allowed after a refund, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package pal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/cloudflare/pal/decrypter"
+	"github.com/cloudflare/pal/log"
+	"github.com/cloudflare/pal/trustedlabels"
+
+	"gopkg.in/yaml.v2"
+)
+
+// serverState holds the decrypters, the labels retrievers and the
+// authorization settings of a Server, which Reload replaces as a whole. A
+// request uses the state current when it started until it completes, and a
+// replaced state is closed once the last of these requests completes.
+//
+// The listeners, the limits, the rate limits, the tripwire and the audit sinks
+// are only configured when the server starts.
+type serverState struct {
+	decrypters map[string]decrypter.Decrypter
+	// backendLimits bounds the number of secrets decrypted at once by each
+	// backend across all requests.
+	backendLimits   map[string]chan struct{}
+	labelsRetriever trustedlabels.Retriever
+	certRetriever   trustedlabels.CertificateRetriever
+	labelPolicies   map[string]*LabelPolicy
+	contextLabels   map[string][]string
+	enforcement     *EnforcementConfig
+	requireSealing  bool
+	disableV1       bool
+	// hash identifies the configuration, see hashConfig. hashKey is the key
+	// of the digests of the passwords in it.
+	hash    string
+	hashKey []byte
+
+	// refs counts the requests using the state, which is closed when it
+	// drops to zero once the state is retired.
+	mu      sync.Mutex
+	refs    int
+	retired bool
+}
+
+// newServerState builds the state configured by config. The backends whose
+// concurrency is unchanged keep the limits of prev, if any, so that the
+// requests completing with prev still count against them.
+func newServerState(config *ServerConfigEntry, prev *serverState) (*serverState, error) {
+	decrypters := make(map[string]decrypter.Decrypter)
+	if config.ROServer != "" {
+		roDecrypter, err := decrypter.NewRODecrypter(config.User, config.Password,
+			config.ROServer, config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		decrypters["ro"] = roDecrypter
+	}
+	if config.PGPKeyRingPath != "" {
+		pgpDecrypter, err := decrypter.NewPGPDecrypter(config.PGPCipher, config.PGPHash,
+			config.PGPKeyRingPath, config.PGPPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		decrypters["pgp"] = pgpDecrypter
+	}
+	if len(decrypters) == 0 {
+		return nil, fmt.Errorf("not found any valid decrypter configuration")
+	}
+
+	for backend := range config.BackendConcurrency {
+		if _, ok := decrypters[backend]; !ok {
+			return nil, fmt.Errorf("invalid backend concurrency: unknown backend %q", backend)
+		}
+	}
+
+	for label, policy := range config.LabelPolicies {
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("invalid policy for label %s: %v", label, err)
+		}
+	}
+
+	if limits := config.RateLimits; limits != nil && limits.Image != nil && !config.LabelsEnabled {
+		return nil, fmt.Errorf("invalid image rate limit: the images of the callers are only known with labels_enabled")
+	}
+
+	if config.Enforcement != nil {
+		if err := config.Enforcement.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the key is kept across reloads, so that the hash only changes with
+	// the configuration
+	var hashKey []byte
+	if prev != nil {
+		hashKey = prev.hashKey
+	} else {
+		hashKey = make([]byte, sha256.Size)
+		if _, err := rand.Read(hashKey); err != nil {
+			return nil, fmt.Errorf("failed to generate the configuration hash key: %v", err)
+		}
+	}
+
+	st := &serverState{
+		decrypters:     decrypters,
+		backendLimits:  make(map[string]chan struct{}),
+		certRetriever:  trustedlabels.NewCertificate(config.CertificateLabels),
+		labelPolicies:  config.LabelPolicies,
+		contextLabels:  config.SecurityContextLabels,
+		enforcement:    config.Enforcement,
+		requireSealing: config.RequireSealing,
+		disableV1:      config.DisableV1,
+		hash:           hashConfig(config, hashKey),
+		hashKey:        hashKey,
+	}
+
+	if config.LabelsEnabled {
+		var err error
+		st.labelsRetriever, err = newLabelsRetriever(config, config.LabelsRetriever, config.LabelsComposite, config.SecurityContextLabels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for backend := range decrypters {
+		n := config.BackendConcurrency[backend]
+		if n <= 0 {
+			n = defaultBackendConcurrency
+		}
+		if prev != nil {
+			if limit, ok := prev.backendLimits[backend]; ok && cap(limit) == n {
+				st.backendLimits[backend] = limit
+				continue
+			}
+		}
+		st.backendLimits[backend] = make(chan struct{}, n)
+	}
+	return st, nil
+}
+
+// state returns the current state of s.
+func (s *Server) state() *serverState {
+	return s.current.Load().(*serverState)
+}
+
+// acquireState returns the current state of s, which is not closed until the
+// matching call to its release.
+func (s *Server) acquireState() *serverState {
+	for {
+		// a state retired in between is already replaced
+		if st := s.state(); st.acquire() {
+			return st
+		}
+	}
+}
+
+func (st *serverState) acquire() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.retired {
+		return false
+	}
+	st.refs++
+	return true
+}
+
+func (st *serverState) release() {
+	st.mu.Lock()
+	st.refs--
+	closing := st.retired && st.refs == 0
+	st.mu.Unlock()
+	if closing {
+		st.close()
+	}
+}
+
+// retire closes st once the requests using it complete. It must no longer be
+// the current state.
+func (st *serverState) retire() {
+	st.mu.Lock()
+	st.retired = true
+	closing := st.refs == 0
+	st.mu.Unlock()
+	if closing {
+		st.close()
+	}
+}
+
+// close releases the resources of the decrypters and labels retrievers of st,
+// such as the connections of the Docker client.
+func (st *serverState) close() {
+	closers := []interface{}{st.labelsRetriever}
+	for _, d := range st.decrypters {
+		closers = append(closers, d)
+	}
+	for _, c := range closers {
+		if c, ok := c.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Warningf("Failed to close the replaced configuration: %v", err)
+			}
+		}
+	}
+}
+
+// Reload reads the entry of environment in the configuration file at path and
+// replaces the decrypters, the labels retrievers and the authorization settings
+// of s with it. The requests being served complete with the previous ones. If
+// the configuration is invalid, s keeps its current one.
+func (s *Server) Reload(path, environment string) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	config, err := loadServerConfigFile(path, environment)
+	if err != nil {
+		s.reloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	prev := s.state()
+	st, err := newServerState(config, prev)
+	if err != nil {
+		s.reloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	s.current.Store(st)
+	prev.retire()
+	s.reloads.WithLabelValues("success").Inc()
+	s.configHash.Reset()
+	s.configHash.WithLabelValues(st.hash).Set(1)
+	log.Infof("Reloaded the %s configuration of %s", environment, path)
+	return nil
+}
+
+func loadServerConfigFile(path, environment string) (*ServerConfigEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadServerConfigEntry(f, environment)
+}
+
+// hashConfig returns the hex-encoded SHA-256 hash of config, to tell which
+// configuration a pald instance runs without exposing it. The passwords are
+// replaced by their HMAC with key: the hash changes when they are rotated, but
+// since it is exported as a metric it must not allow to check guesses of them.
+func hashConfig(config *ServerConfigEntry, key []byte) string {
+	redacted := *config
+	redacted.Password = keyedDigest(key, config.Password)
+	redacted.PGPPassphrase = keyedDigest(key, config.PGPPassphrase)
+	buf, err := yaml.Marshal(&redacted)
+	if err != nil {
+		log.Errorf("Failed to marshal configuration: %v", err)
+		return ""
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func keyedDigest(key []byte, secret string) string {
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, secret)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,103 @@
+package pal
+
+import (
+	"fmt"
+
+	"github.com/cloudflare/pal/trustedlabels"
+)
+
+// CompositeConfig configures a labels retriever that combines the labels
+// granted by several sources. Mode is either "union", granting the labels
+// granted by any source, or "intersect", granting only the labels granted by
+// all sources.
+//
+// The sources are "docker", "security_context" or a nested "composite". The
+// following requires both a trusted image signature and a confinement of the
+// caller granting the label, by its SELinux type or by its AppArmor profile:
+//  labels_retriever: composite
+//  labels_composite:
+//    mode: intersect
+//    sources:
+//    - retriever: docker
+//    - name: confinement
+//      retriever: composite
+//      composite:
+//        mode: union
+//        sources:
+//        - name: selinux
+//          retriever: security_context
+//          labels:
+//            selinux_type:myapp_t: [db-prod]
+//        - name: apparmor
+//          retriever: security_context
+//          labels:
+//            apparmor:myapp: [db-prod]
+type CompositeConfig struct {
+	Mode    string             `yaml:"mode,omitempty"`
+	Sources []*RetrieverConfig `yaml:"sources,omitempty"`
+}
+
+// RetrieverConfig configures a single source of a composite labels retriever.
+// Name identifies the source in decision traces and defaults to Retriever. The
+// names of the sources of a composite must be distinct.
+type RetrieverConfig struct {
+	Name      string           `yaml:"name,omitempty"`
+	Retriever string           `yaml:"retriever,omitempty"`
+	Composite *CompositeConfig `yaml:"composite,omitempty"`
+	// Labels are the labels granted by a security_context source, keyed like
+	// security_context_labels.
+	Labels map[string][]string `yaml:"labels,omitempty"`
+}
+
+// newLabelsRetriever constructs the labels retriever of the given type. labels
+// are the labels granted by a security_context retriever. The "mocker" type
+// yields a nil Retriever, as tests are expected to provide their own.
+func newLabelsRetriever(config *ServerConfigEntry, retriever string, composite *CompositeConfig, labels map[string][]string) (trustedlabels.Retriever, error) {
+	switch retriever {
+	case "docker":
+		return trustedlabels.NewDocker(config.NotaryTrustServer, config.NotaryTrustDir,
+			config.NotaryTrustCacheTTL)
+	case "composite":
+		if composite == nil || len(composite.Sources) == 0 {
+			return nil, fmt.Errorf("composite labels retriever without sources")
+		}
+		var sources []trustedlabels.Source
+		names := make(map[string]bool)
+		for _, sc := range composite.Sources {
+			if sc.Retriever == "mocker" {
+				return nil, fmt.Errorf("invalid composite labels retriever source %s", sc.Retriever)
+			}
+			r, err := newLabelsRetriever(config, sc.Retriever, sc.Composite, sc.Labels)
+			if err != nil {
+				return nil, err
+			}
+			name := sc.Name
+			if name == "" {
+				name = sc.Retriever
+			}
+			if names[name] {
+				return nil, fmt.Errorf("duplicate composite labels retriever source %s, sources must have distinct names", name)
+			}
+			names[name] = true
+			sources = append(sources, trustedlabels.Source{Name: name, Retriever: r})
+		}
+		switch composite.Mode {
+		case "union":
+			return trustedlabels.NewUnion(sources...), nil
+		case "intersect":
+			return trustedlabels.NewIntersection(sources...), nil
+		default:
+			return nil, fmt.Errorf("invalid composite labels retriever mode %q", composite.Mode)
+		}
+	case "security_context":
+		if len(labels) == 0 {
+			return nil, fmt.Errorf("security_context labels retriever without labels")
+		}
+		return &securityContextRetriever{labels: labels}, nil
+	case "mocker":
+		// do nothing. We assumes that tests will replace the retriever
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid labels retriever %s", retriever)
+	}
+}
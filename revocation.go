@@ -0,0 +1,154 @@
+package pal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/pal/decrypter"
+	"github.com/cloudflare/pal/log"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RevocationList is the content of the revocation file, which invalidates
+// leaked ciphertexts, or every secret carrying a compromised label or encrypted
+// to a compromised key, without re-keying the backends:
+//  ciphertexts:
+//    - 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//  labels:
+//    - db-prod
+//  key_ids:
+//    - 6C7EE1B8621CC013
+type RevocationList struct {
+	// Ciphertexts are the hex-encoded SHA-256 hashes of the base64-decoded
+	// ciphertexts, i.e. of what follows "pgp:" or "ro:" in a PAL value.
+	Ciphertexts []string `yaml:"ciphertexts,omitempty"`
+	Labels      []string `yaml:"labels,omitempty"`
+	// KeyIDs are the 16 hex digit IDs of PGP keys.
+	KeyIDs []string `yaml:"key_ids,omitempty"`
+}
+
+// revocationCheckInterval is the minimum time between two checks that the
+// revocation file changed.
+const revocationCheckInterval = time.Second
+
+// revocations holds the revocation list of the revocation file, which it
+// reloads when the file changes. If a new version of the file is invalid, the
+// previous list is kept.
+type revocations struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	size    int64
+
+	ciphertexts map[string]bool
+	labels      map[string]bool
+	keyIDs      map[string]bool
+}
+
+// newRevocations loads the revocation file at path.
+func newRevocations(path string) (*revocations, error) {
+	r := &revocations{path: path, now: time.Now}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the revocation file. r.mu must be held, unless r is new.
+func (r *revocations) load() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to read revocation file: %v", err)
+	}
+	buf, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to read revocation file: %v", err)
+	}
+	var list RevocationList
+	if err := yaml.Unmarshal(buf, &list); err != nil {
+		return fmt.Errorf("failed to parse revocation file %s: %v", r.path, err)
+	}
+
+	ciphertexts := make(map[string]bool)
+	for _, hash := range list.Ciphertexts {
+		hash = strings.ToLower(hash)
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid ciphertext hash %q in revocation file %s", hash, r.path)
+		}
+		ciphertexts[hash] = true
+	}
+	r.ciphertexts, r.labels, r.keyIDs = ciphertexts, toSet(list.Labels), make(map[string]bool)
+	for _, id := range list.KeyIDs {
+		r.keyIDs[strings.ToUpper(strings.TrimPrefix(id, "0x"))] = true
+	}
+	r.modTime, r.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// refresh reloads the revocation file if it changed since it was last loaded,
+// checking at most once per revocationCheckInterval. r.mu must be held.
+func (r *revocations) refresh() {
+	now := r.now()
+	if now.Sub(r.checked) < revocationCheckInterval {
+		return
+	}
+	r.checked = now
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		log.Errorf("Failed to check revocation file, keeping the current list: %v", err)
+		return
+	}
+	if fi.ModTime().Equal(r.modTime) && fi.Size() == r.size {
+		return
+	}
+	if err := r.load(); err != nil {
+		log.Errorf("%v, keeping the current list", err)
+		return
+	}
+	log.Infof("Reloaded revocation file %s", r.path)
+}
+
+// revoked returns why the secret decrypted from ciphertext is revoked, or an
+// empty string if it is not. A nil revocations revokes nothing.
+func (r *revocations) revoked(ciphertext []byte, secret *decrypter.Secret) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh()
+
+	sum := sha256.Sum256(ciphertext)
+	if hash := hex.EncodeToString(sum[:]); r.ciphertexts[hash] {
+		return fmt.Sprintf("ciphertext %s is revoked", hash)
+	}
+	for _, label := range secret.Labels {
+		if r.labels[label] {
+			return fmt.Sprintf("label %s is revoked", label)
+		}
+	}
+	for _, id := range secret.KeyIDs {
+		if r.keyIDs[id] {
+			return fmt.Sprintf("key %s is revoked", id)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package pal
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/pal/decrypter"
+
+	"github.com/joshlf/testutil"
+)
+
+func TestRevocations(t *testing.T) {
+	tempdir := testutil.MustTempDir(t, "", "pal-revocation")
+	defer os.RemoveAll(tempdir)
+	path := filepath.Join(tempdir, "revoked.yaml")
+	sum := sha256.Sum256([]byte("leaked"))
+	hash := hex.EncodeToString(sum[:])
+	testutil.MustPrefix(t, "could not write revocation file", ioutil.WriteFile(path, []byte("ciphertexts: ["+hash+"]\nlabels: [db-prod]\n"), 0600))
+
+	r, err := newRevocations(path)
+	testutil.MustPrefix(t, "could not load revocation file", err)
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+	secret := &decrypter.Secret{Labels: []string{"app-foo"}, KeyIDs: []string{"6C7EE1B8621CC013"}}
+
+	tests := []struct {
+		ciphertext string
+		labels     []string
+		want       string
+	}{
+		{"leaked", []string{"app-foo"}, "ciphertext " + hash + " is revoked"},
+		{"fine", []string{"app-foo", "db-prod"}, "label db-prod is revoked"},
+		{"fine", []string{"app-foo"}, ""},
+	}
+	for _, test := range tests {
+		secret.Labels = test.labels
+		if got := r.revoked([]byte(test.ciphertext), secret); got != test.want {
+			t.Errorf("revoked(%q, %v): want %q, got %q", test.ciphertext, test.labels, test.want, got)
+		}
+	}
+
+	// the file is reloaded when it changes, and kept when it is invalid
+	testutil.MustPrefix(t, "could not write revocation file", ioutil.WriteFile(path, []byte("key_ids: [6c7ee1b8621cc013]\n"), 0600))
+	testutil.MustPrefix(t, "could not touch revocation file", os.Chtimes(path, now, now.Add(time.Hour)))
+	now = now.Add(revocationCheckInterval)
+	if got := r.revoked([]byte("fine"), secret); got != "key 6C7EE1B8621CC013 is revoked" {
+		t.Errorf("want revoked key after reload, got %q", got)
+	}
+	testutil.MustPrefix(t, "could not write revocation file", ioutil.WriteFile(path, []byte("ciphertexts: [nothex]\n"), 0600))
+	testutil.MustPrefix(t, "could not touch revocation file", os.Chtimes(path, now, now.Add(2*time.Hour)))
+	now = now.Add(revocationCheckInterval)
+	if got := r.revoked([]byte("fine"), secret); got == "" {
+		t.Error("want the previous list to be kept after an invalid change")
+	}
+
+	var nilRevocations *revocations
+	if got := nilRevocations.revoked([]byte("leaked"), secret); got != "" {
+		t.Errorf("want a nil revocations to revoke nothing, got %q", got)
+	}
+}
+
+func TestServerRevokedSecret(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	value := mustPGPEncrypt(t, "foo", "app-foo")
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "pgp:"))
+	testutil.MustPrefix(t, "could not decode ciphertext", err)
+	sum := sha256.Sum256(ciphertext)
+	path := filepath.Join(tempdir, "revoked.yaml")
+	testutil.MustPrefix(t, "could not write revocation file", ioutil.WriteFile(path, []byte("ciphertexts: ["+hex.EncodeToString(sum[:])+"]\n"), 0600))
+
+	server, err := NewServer(&ServerConfigEntry{
+		PGPKeyRingPath: "testdata/secring.gpg",
+		PGPPassphrase:  "paltest",
+		RevocationFile: path,
+	})
+	testutil.MustPrefix(t, "could not create pald server", err)
+	go server.ServeRPC(listener)
+
+	config := &ConfigEntry{Envs: map[string]string{"FOO": value}}
+	if err := newClientV2(config, listener.Addr().String()).Decrypt(); err == nil || !strings.Contains(err.Error(), "code: 106") {
+		t.Errorf("want revoked error, got %v", err)
+	}
+	config.Envs = map[string]string{"FOO": mustPGPEncrypt(t, "foo", "app-foo")}
+	testutil.MustPrefix(t, "could not decrypt secrets", newClientV2(config, listener.Addr().String()).Decrypt())
+}
@@ -0,0 +1,96 @@
+package pal
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// A response is sealed to the ephemeral X25519 public key that the client sends
+// in the seal_key field of its request. pald seals each secret with NaCl box,
+// using an ephemeral key pair of its own whose public key it returns in the
+// seal_key field of the response, and a random nonce which is prepended to the
+// sealed secret. Only the client can then open the secrets, so they never cross
+// the socket, or a proxy in a TCP deployment, in plain text.
+
+const sealNonceSize = 24
+
+// parseSealKey decodes a base64-encoded X25519 public key.
+func parseSealKey(s string) (*[32]byte, error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seal key: %v", err)
+	}
+	if len(buf) != 32 {
+		return nil, fmt.Errorf("invalid seal key: want 32 bytes, got %d", len(buf))
+	}
+	key := new([32]byte)
+	copy(key[:], buf)
+	return key, nil
+}
+
+// sealSecrets seals the plaintexts of secrets to peerKey. It returns the public
+// key of the ephemeral key pair used for sealing and the base64-encoded sealed
+// secrets.
+func sealSecrets(peerKey *[32]byte, secrets map[string]string) (string, map[string]string, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zero(priv[:])
+
+	var shared [32]byte
+	box.Precompute(&shared, peerKey, priv)
+	defer zero(shared[:])
+
+	sealed := make(map[string]string, len(secrets))
+	for k, v := range secrets {
+		var nonce [sealNonceSize]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return "", nil, err
+		}
+		out := box.SealAfterPrecomputation(nonce[:], []byte(v), &nonce, &shared)
+		sealed[k] = base64.StdEncoding.EncodeToString(out)
+	}
+	return base64.StdEncoding.EncodeToString(pub[:]), sealed, nil
+}
+
+// openSecrets opens secrets sealed by sealSecrets with the ephemeral public key
+// peerKey, using the private key priv whose public key was sent to pald.
+func openSecrets(priv *[32]byte, peerKey string, sealed map[string]string) (map[string]string, error) {
+	pub, err := parseSealKey(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	var shared [32]byte
+	box.Precompute(&shared, pub, priv)
+	defer zero(shared[:])
+
+	secrets := make(map[string]string, len(sealed))
+	for k, v := range sealed {
+		buf, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sealed secret %s: %v", k, err)
+		}
+		if len(buf) < sealNonceSize {
+			return nil, fmt.Errorf("invalid sealed secret %s: too short", k)
+		}
+		var nonce [sealNonceSize]byte
+		copy(nonce[:], buf)
+		plaintext, ok := box.OpenAfterPrecomputation(nil, buf[sealNonceSize:], &nonce, &shared)
+		if !ok {
+			return nil, errors.New("failed to open sealed secret " + k)
+		}
+		secrets[k] = string(plaintext)
+	}
+	return secrets, nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,94 @@
+package pal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/cloudflare/pal/trustedlabels"
+)
+
+// securityContext is the parsed LSM label of a peer. Depending on the LSM in
+// use on the host, either the SELinux or the AppArmor fields are set.
+type securityContext struct {
+	Raw string
+
+	SELinuxUser  string
+	SELinuxRole  string
+	SELinuxType  string
+	SELinuxLevel string
+
+	AppArmorProfile string
+}
+
+// parseSecurityContext parses an LSM label as returned by SO_PEERSEC, i.e. an
+// SELinux context such as "system_u:system_r:container_t:s0:c1,c2" or an
+// AppArmor profile such as "docker-default (enforce)".
+func parseSecurityContext(raw string) *securityContext {
+	ctx := &securityContext{Raw: raw}
+	for _, mode := range []string{" (enforce)", " (complain)"} {
+		if strings.HasSuffix(raw, mode) {
+			ctx.AppArmorProfile = strings.TrimSuffix(raw, mode)
+			return ctx
+		}
+	}
+	if raw == "unconfined" {
+		ctx.AppArmorProfile = raw
+		return ctx
+	}
+	// the MLS/MCS level may itself contain colons
+	if parts := strings.SplitN(raw, ":", 4); len(parts) == 4 {
+		ctx.SELinuxUser = parts[0]
+		ctx.SELinuxRole = parts[1]
+		ctx.SELinuxType = parts[2]
+		ctx.SELinuxLevel = parts[3]
+	}
+	return ctx
+}
+
+// keys returns the identifiers under which labels may be granted to ctx:
+// "selinux_type:<type>", "selinux_level:<level>" and "apparmor:<profile>".
+func (ctx *securityContext) keys() []string {
+	var keys []string
+	if ctx.SELinuxType != "" {
+		keys = append(keys, "selinux_type:"+ctx.SELinuxType)
+	}
+	if ctx.SELinuxLevel != "" {
+		keys = append(keys, "selinux_level:"+ctx.SELinuxLevel)
+	}
+	if ctx.AppArmorProfile != "" {
+		keys = append(keys, "apparmor:"+ctx.AppArmorProfile)
+	}
+	return keys
+}
+
+// securityContextRetriever grants labels to a process by its LSM label, read
+// from /proc/<pid>/attr/current. Unlike security_context_labels, which are
+// granted on top of the labels retriever, it can be combined with other sources
+// by a composite retriever, e.g. to require both a trusted image and a
+// confinement.
+type securityContextRetriever struct {
+	labels map[string][]string
+}
+
+func (r *securityContextRetriever) IdentityForPID(pid int) (*trustedlabels.Identity, error) {
+	buf, err := ioutil.ReadFile(procPath(pid, "attr/current"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read security context of pid %d: %v", pid, err)
+	}
+	raw := string(bytes.TrimRight(buf, "\x00\n"))
+	identity := &trustedlabels.Identity{
+		Labels:     make(map[string]struct{}),
+		Attributes: map[string]string{"security_context": raw},
+	}
+	if raw == "" {
+		return identity, nil
+	}
+	for _, key := range parseSecurityContext(raw).keys() {
+		for _, label := range r.labels[key] {
+			identity.Labels[label] = struct{}{}
+		}
+	}
+	return identity, nil
+}
@@ -2,14 +2,25 @@ package pal
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/cloudflare/pal/audit"
 	"github.com/cloudflare/pal/decrypter"
 	"github.com/cloudflare/pal/log"
 	"github.com/cloudflare/pal/trustedlabels"
@@ -41,18 +52,112 @@ type ServerConfigEntry struct {
 	PGPPassphrase  string `yaml:"pgp_passphrase,omitempty"`
 	PGPHash        string `yaml:"pgp_hash,omitempty"`
 
-	LabelsEnabled     bool   `yaml:"labels_enabled,omitempty"`
-	LabelsRetriever   string `yaml:"labels_retriever,omitempty"`
-	NotaryTrustServer string `yaml:"notary_trust_server,omitempty"`
-	NotaryTrustDir    string `yaml:"notary_trust_dir,omitempty"`
+	LabelsEnabled       bool             `yaml:"labels_enabled,omitempty"`
+	LabelsRetriever     string           `yaml:"labels_retriever,omitempty"`
+	LabelsComposite     *CompositeConfig `yaml:"labels_composite,omitempty"`
+	NotaryTrustServer   string           `yaml:"notary_trust_server,omitempty"`
+	NotaryTrustDir      string           `yaml:"notary_trust_dir,omitempty"`
+	NotaryTrustCacheTTL time.Duration    `yaml:"notary_trust_cache_ttl,omitempty"`
+
+	LabelPolicies         map[string]*LabelPolicy `yaml:"label_policies,omitempty"`
+	SecurityContextLabels map[string][]string     `yaml:"security_context_labels,omitempty"`
+	Enforcement           *EnforcementConfig      `yaml:"enforcement,omitempty"`
+
+	MaxMessageSize             int64             `yaml:"max_message_size,omitempty"`
+	MaxSecretsPerRequest       int               `yaml:"max_secrets_per_request,omitempty"`
+	MaxCiphertextSize          int               `yaml:"max_ciphertext_size,omitempty"`
+	ReadTimeout                time.Duration     `yaml:"read_timeout,omitempty"`
+	WriteTimeout               time.Duration     `yaml:"write_timeout,omitempty"`
+	RPCWorkers                 int               `yaml:"rpc_workers,omitempty"`
+	RPCMaxConnections          int               `yaml:"rpc_max_connections,omitempty"`
+	MaxConnectionsPerUID       int               `yaml:"max_connections_per_uid,omitempty"`
+	MaxConnectionsPerContainer int               `yaml:"max_connections_per_container,omitempty"`
+	RateLimits                 *RateLimitsConfig `yaml:"rate_limits,omitempty"`
+	// TripwireFile enables alerts on the labels requested for the first time
+	// by an image digest, and holds those seen so far.
+	TripwireFile string `yaml:"tripwire_file,omitempty"`
+	// RevocationFile holds the RevocationList, reloaded when it changes.
+	RevocationFile string `yaml:"revocation_file,omitempty"`
+
+	DecryptConcurrency int            `yaml:"decrypt_concurrency,omitempty"`
+	BackendConcurrency map[string]int `yaml:"backend_concurrency,omitempty"`
+
+	TLSCert           string              `yaml:"tls_cert,omitempty"`
+	TLSKey            string              `yaml:"tls_key,omitempty"`
+	TLSClientCA       string              `yaml:"tls_client_ca,omitempty"`
+	CertificateLabels map[string][]string `yaml:"certificate_labels,omitempty"`
+	RequireSealing    bool                `yaml:"require_sealing,omitempty"`
+
+	DisableV1 bool `yaml:"disable_v1,omitempty"`
+
+	AuditLog   string             `yaml:"audit_log,omitempty"`
+	AuditSinks []*AuditSinkConfig `yaml:"audit_sinks,omitempty"`
+
+	// AdminUIDs may use the admin socket, besides root and the user running
+	// pald.
+	AdminUIDs []int `yaml:"admin_uids,omitempty"`
 }
 
 // Server represents a PAL server capable of servicing deryption requests. It
 // provides the core functionality for the 'pald' daemon.
 type Server struct {
-	counter         *prometheus.CounterVec
-	labelsRetriever trustedlabels.Retriever
-	decrypters      map[string]decrypter.Decrypter
+	counter    *prometheus.CounterVec
+	v1Requests *prometheus.CounterVec
+	auditSink  audit.Sink
+	rejections *prometheus.CounterVec
+	// recent holds the latest decisions, listed by palctl.
+	recent *decisionLog
+
+	errors           *prometheus.CounterVec
+	denials          *prometheus.CounterVec
+	shadowDenials    *prometheus.CounterVec
+	requestDuration  prometheus.Histogram
+	identityDuration prometheus.Histogram
+	backendDuration  *prometheus.HistogramVec
+	connections      prometheus.Gauge
+
+	// current holds the *serverState replaced by Reload.
+	current    atomic.Value
+	reloadMu   sync.Mutex
+	reloads    *prometheus.CounterVec
+	configHash *prometheus.GaugeVec
+
+	// draining is closed by Shutdown, which closes listeners and drains conns.
+	draining  chan struct{}
+	connMu    sync.Mutex
+	listeners map[net.Listener]struct{}
+	pools     map[*workerPool]struct{}
+	conns     map[net.Conn]struct{}
+	connWG    sync.WaitGroup
+
+	// limits of a single request
+	maxMessageSize    int64
+	maxSecrets        int
+	maxCiphertextSize int
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	// peers caps the connections of each uid and container.
+	peers *peerLimiter
+	// rate limits of decryptions, nil if unlimited
+	containerRate *rateLimiter
+	imageRate     *rateLimiter
+	labelRate     *rateLimiter
+	tripwire      *tripwire
+	firstSeen     *prometheus.CounterVec
+	// revocations is nil if there is no revocation file.
+	revocations *revocations
+	// rpcWorkers and rpcMaxConnections bound the number of requests being
+	// decrypted and of connections being served.
+	rpcWorkers        int
+	rpcMaxConnections int
+	// decryptConcurrency bounds the number of secrets of a single request
+	// decrypted at once.
+	decryptConcurrency int
+
+	// state of the admin socket
+	started   time.Time
+	adminUIDs []int
+	lockdown  *lockdown
 }
 
 // LoadServerConfigEntry reads and parses r as a PAL server YAML configuration
@@ -76,112 +181,238 @@ func LoadServerConfigEntry(r io.Reader, environment string) (*ServerConfigEntry,
 	return nil, fmt.Errorf("missing config section %q", environment)
 }
 
-// NewServer constructs a new Server that supports versions 1 and 2 of the PAL
-// protocol.
+// Defaults of the RPC server limits.
+const (
+	defaultMaxMessageSize    = 4 << 20
+	defaultMaxSecrets        = 256
+	defaultMaxCiphertextSize = 1 << 20
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultRPCWorkers        = 64
+	defaultRPCMaxConnections = 1024
+
+	defaultDecryptConcurrency = 8
+	defaultBackendConcurrency = 32
+)
+
+// NewServer constructs a new Server that supports versions 1, 2 and 3 of the
+// PAL protocol.
 func NewServer(config *ServerConfigEntry) (s *Server, err error) {
-	decrypters := make(map[string]decrypter.Decrypter)
-	if config.ROServer != "" {
-		roDecrypter, err := decrypter.NewRODecrypter(config.User, config.Password,
-			config.ROServer, config.CABundle)
-		if err != nil {
-			return nil, err
-		}
-		decrypters["ro"] = roDecrypter
-	}
-	if config.PGPKeyRingPath != "" {
-		pgpDecrypter, err := decrypter.NewPGPDecrypter(config.PGPCipher, config.PGPHash,
-			config.PGPKeyRingPath, config.PGPPassphrase)
-		if err != nil {
-			return nil, err
-		}
-		decrypters["pgp"] = pgpDecrypter
-	}
-	if len(decrypters) == 0 {
-		return nil, fmt.Errorf("not found any valid decrypter configuration")
+	st, err := newServerState(config, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	s = &Server{
-		decrypters: decrypters,
+		maxMessageSize:     config.MaxMessageSize,
+		maxSecrets:         config.MaxSecretsPerRequest,
+		maxCiphertextSize:  config.MaxCiphertextSize,
+		readTimeout:        config.ReadTimeout,
+		writeTimeout:       config.WriteTimeout,
+		peers:              newPeerLimiter(config.MaxConnectionsPerUID, config.MaxConnectionsPerContainer),
+		rpcWorkers:         config.RPCWorkers,
+		rpcMaxConnections:  config.RPCMaxConnections,
+		decryptConcurrency: config.DecryptConcurrency,
+		started:            time.Now(),
+		adminUIDs:          config.AdminUIDs,
+		lockdown:           newLockdown(),
+		recent:             newDecisionLog(recentDecisions),
 		counter: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "decryptions",
-			Help: "Decryption requests by label",
+			Help: "Secrets returned by label",
+		}, []string{"label"}),
+		v1Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "v1_requests",
+			Help: "Requests using the legacy HTTP protocol by result",
+		}, []string{"result"}),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rejections",
+			Help: "Requests and connections rejected for exceeding a limit by reason",
+		}, []string{"reason"}),
+		firstSeen: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "first_seen_labels",
+			Help: "Labels requested for the first time by an image digest by label",
 		}, []string{"label"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "errors",
+			Help: "Error responses by error code and backend of the failing secret",
+		}, []string{"code", "backend"}),
+		denials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "denials",
+			Help: "Secrets denied to a caller by label and image",
+		}, []string{"label", "image"}),
+		shadowDenials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shadow_denials",
+			Help: "Secrets returned in shadow enforcement mode which would have been denied by label and image",
+		}, []string{"label", "image"}),
+		requestDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "request_duration_seconds",
+			Help: "Time taken to serve a decryption request",
+		}),
+		identityDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "identity_lookup_duration_seconds",
+			Help: "Time taken to look up the identity and labels of a caller",
+		}),
+		backendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "backend_decrypt_duration_seconds",
+			Help: "Time taken to decrypt a secret by backend",
+		}, []string{"backend"}),
+		connections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "active_connections",
+			Help: "RPC connections being served",
+		}),
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "config_reloads",
+			Help: "Configuration reloads by result",
+		}, []string{"result"}),
+		configHash: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "config_hash",
+			Help: "SHA-256 hash of the configuration in use, always 1",
+		}, []string{"sha256"}),
 	}
+	s.draining = make(chan struct{})
+	s.listeners = make(map[net.Listener]struct{})
+	s.pools = make(map[*workerPool]struct{})
+	s.conns = make(map[net.Conn]struct{})
+	s.current.Store(st)
+	s.configHash.WithLabelValues(st.hash).Set(1)
 
-	if config.LabelsEnabled {
-		switch config.LabelsRetriever {
-		case "docker":
-			s.labelsRetriever, err = trustedlabels.NewDocker(config.NotaryTrustServer, config.NotaryTrustDir)
-			if err != nil {
-				return nil, err
-			}
-		case "mocker":
-			// do nothing. We assumes that tests will replace the retriever
-		default:
-			return nil, fmt.Errorf("invalid labels retriever %s", config.LabelsRetriever)
+	if s.maxMessageSize <= 0 {
+		s.maxMessageSize = defaultMaxMessageSize
+	}
+	if s.maxSecrets <= 0 {
+		s.maxSecrets = defaultMaxSecrets
+	}
+	if s.maxCiphertextSize <= 0 {
+		s.maxCiphertextSize = defaultMaxCiphertextSize
+	}
+	if s.readTimeout <= 0 {
+		s.readTimeout = defaultReadTimeout
+	}
+	if s.writeTimeout <= 0 {
+		s.writeTimeout = defaultWriteTimeout
+	}
+	if s.rpcWorkers <= 0 {
+		s.rpcWorkers = defaultRPCWorkers
+	}
+	if s.rpcMaxConnections <= 0 {
+		s.rpcMaxConnections = defaultRPCMaxConnections
+	}
+	if s.decryptConcurrency <= 0 {
+		s.decryptConcurrency = defaultDecryptConcurrency
+	}
+
+	if limits := config.RateLimits; limits != nil {
+		if s.containerRate, err = newRateLimiter(limits.Container); err != nil {
+			return nil, fmt.Errorf("invalid container rate limit: %v", err)
+		}
+		if s.imageRate, err = newRateLimiter(limits.Image); err != nil {
+			return nil, fmt.Errorf("invalid image rate limit: %v", err)
+		}
+		if s.labelRate, err = newRateLimiter(limits.Label); err != nil {
+			return nil, fmt.Errorf("invalid label rate limit: %v", err)
 		}
 	}
+	if config.TripwireFile != "" {
+		if s.tripwire, err = newTripwire(config.TripwireFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.RevocationFile != "" {
+		if s.revocations, err = newRevocations(config.RevocationFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.auditSink, err = newAuditSink(config.AuditLog, config.AuditSinks); err != nil {
+		return nil, err
+	}
 
 	if !testMode {
-		prometheus.MustRegister(s.counter)
+		prometheus.MustRegister(s.counter, s.v1Requests, s.rejections, s.firstSeen, s.reloads, s.configHash,
+			s.errors, s.denials, s.shadowDenials, s.requestDuration, s.identityDuration, s.backendDuration, s.connections)
+		prometheus.MustRegister(s.retrieverCacheMetrics()...)
 	}
 	return s, nil
 }
 
+// peerContextKey is the context key of the peer of an HTTP connection.
+type peerContextKey struct{}
+
+type peerContext struct {
+	conn *conn
+	err  error
+}
+
+// ConnContext identifies the peer of an HTTP connection, in the same way as
+// for RPC connections, and stores it in ctx for ServeHTTP. It must be set as
+// the ConnContext of the http.Server serving the legacy protocol.
+func (s *Server) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	pc, err := identifyPeer(c)
+	return context.WithValue(ctx, peerContextKey{}, &peerContext{conn: pc, err: err})
+}
+
+// Timeouts returns the read and write timeouts of the connections of the
+// server, after applying the defaults to the configured ones. The http.Server
+// serving the legacy protocol should use them too.
+func (s *Server) Timeouts() (read, write time.Duration) {
+	return s.readTimeout, s.writeTimeout
+}
+
 // ServeHTTP serves the legacy version 1 of the PAL protocol. It is only capable
-// of handling Red October decryption requests.
+// of handling Red October decryption requests, which are authorized like the
+// requests of the other versions.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+	if s.state().disableV1 {
+		log.Error("Rejected a request using the disabled legacy HTTP protocol")
+		s.v1Requests.WithLabelValues("disabled").Inc()
+		writeDecryptionErrorV1(w, http.StatusGone, "the legacy HTTP protocol is disabled, use the rpc socket", "")
+		return
+	}
+
+	peer, ok := r.Context().Value(peerContextKey{}).(*peerContext)
+	if !ok || peer.err != nil {
+		msg := "failed to retrieve peer credential of the connection"
+		if ok {
+			msg = peer.err.Error()
+		}
+		log.Error(msg)
+		s.v1Requests.WithLabelValues("error").Inc()
+		writeDecryptionErrorV1(w, http.StatusForbidden, msg, "")
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, s.maxMessageSize))
 	if err != nil {
 		log.Errorf("Could not read request body: %v", err)
+		s.v1Requests.WithLabelValues("error").Inc()
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	var dreq decryptionRequest
 	if err = json.Unmarshal(body, &dreq); err != nil {
 		log.Errorf("Could not unmarshal JSON: %v", err)
+		s.v1Requests.WithLabelValues("error").Inc()
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	var dresp decryptionResponse
-	dresp.Secrets = make(map[string]string)
-	for k, encryptedBlob := range dreq.Ciphertexts {
-		data, err := base64.StdEncoding.DecodeString(encryptedBlob)
-		if err != nil {
-			log.Errorf("Error decoding base64-encoded secret: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
 
-		roDecrypter, ok := s.decrypters["ro"]
-		if !ok {
-			log.Error("RO decryter not found. Legacy flow is not supported")
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		secret, err := roDecrypter.Decrypt(bytes.NewBuffer(data))
-		if err != nil {
-			log.Errorf("Decryption request to Red October failed: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
-			e := decryptionErrorV1{
-				Code:   101,
-				Err:    err.Error(),
-				Secret: k,
-			}
-			jsonBytes, jsonErr := json.Marshal(e)
-			if jsonErr != nil {
-				log.Errorf("Failed to marshal error bytes: %v", err)
-			}
-			w.Write(jsonBytes)
-			return
-		}
-		// NB - this assumes all secrets have been safely
-		// encoded for stringification, but the client is
-		// guaranteeing that for us.
-		dresp.Secrets[k] = string(secret.Value)
+	// version 1 ciphertexts are bare Red October ciphertexts
+	for k, v := range dreq.Ciphertexts {
+		dreq.Ciphertexts[k] = "ro:" + v
 	}
-	jsonData, err := json.Marshal(dresp)
+	dreq.Hello, dreq.SealKey = nil, ""
+	dresp := s.decryptRequest(peer.conn, &dreq)
+	if dresp.Error != nil {
+		s.v1Requests.WithLabelValues("error").Inc()
+		writeDecryptionErrorV1(w, http.StatusBadRequest, dresp.Error.Message, dresp.Error.Secret)
+		return
+	}
+	s.v1Requests.WithLabelValues("ok").Inc()
+
+	// NB - this assumes all secrets have been safely encoded for
+	// stringification, but the client is guaranteeing that for us.
+	jsonData, err := json.Marshal(&decryptionResponse{Secrets: dresp.Secrets})
 	if err != nil {
 		log.Errorf("Failed to marshal decryption response: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -190,104 +421,647 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonData)
 }
 
-// ServeRPC serves version 2 of the PAL protocol.
+func writeDecryptionErrorV1(w http.ResponseWriter, status int, msg, secret string) {
+	w.WriteHeader(status)
+	e := decryptionErrorV1{
+		Code:   errCodeGeneric,
+		Err:    msg,
+		Secret: secret,
+	}
+	jsonBytes, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Failed to marshal error bytes: %v", err)
+	}
+	w.Write(jsonBytes)
+}
+
+// ServeRPC serves versions 2 and 3 of the PAL protocol.
+//
+// Requests are decrypted by a fixed pool of workers. At most the configured
+// number of connections are served at once; further connections wait in the
+// listen backlog until a slot is released.
+//
+// After a call to Shutdown, ServeRPC returns ErrServerClosed once the requests
+// it was serving are answered.
 func (s *Server) ServeRPC(l net.Listener) error {
+	pool := newWorkerPool(s.rpcWorkers)
+	defer pool.stop()
+	if !s.trackListener(l, pool) {
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l, pool)
+
+	var served sync.WaitGroup
+	slots := make(chan struct{}, s.rpcMaxConnections)
 	for {
+		slots <- struct{}{}
 		c, err := l.Accept()
 		if err != nil {
+			if s.isDraining() {
+				served.Wait()
+				return ErrServerClosed
+			}
 			return err
 		}
-		ucred, err := getUcred(c)
-		if err != nil {
-			resp := decryptionResponse{
-				Error: &decryptionError{
-					Code:    101,
-					Message: fmt.Sprintf("failed to retrieve peer credential of the connection: %v", err),
-				},
-			}
-			if err := json.NewEncoder(c).Encode(&resp); err != nil {
-				log.Errorf("Failed to send error response: %v", err)
-			}
+		if !s.trackConn(c) {
 			c.Close()
+			<-slots
 			continue
 		}
-		go s.serveRPCConn(&conn{
-			Conn:  c,
-			Ucred: ucred,
-		})
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			defer s.untrackConn(c)
+			defer func() { <-slots }()
+			s.connections.Inc()
+			defer s.connections.Dec()
+			s.serveRPCConn(c, pool)
+		}()
 	}
 }
 
-func (s *Server) serveRPCConn(c *conn) {
-	decoder := json.NewDecoder(c)
-	encoder := json.NewEncoder(c)
-
+func (s *Server) serveRPCConn(c net.Conn, pool *workerPool) {
+	encoder := &responseEncoder{encoder: json.NewEncoder(c), conn: c, timeout: s.writeTimeout}
+	var inflight sync.WaitGroup
 	defer func() {
+		inflight.Wait()
 		if err := c.Close(); err != nil {
 			log.Infof("failed to close connection: %v", err)
 		}
 	}()
 
-	var (
-		authorizedLabels map[string]struct{}
-		err              error
-	)
-	if s.labelsRetriever != nil {
-		authorizedLabels, err = s.labelsRetriever.LabelsForPID(int(c.Pid))
-		if err != nil {
-			writeDecryptionError(encoder, 101, fmt.Sprintf("failed to get authorized labels: %v", err), "")
+	pc, err := identifyPeer(c)
+	if err != nil {
+		s.writeError(encoder, errCodeIdentity, err.Error())
+		return
+	}
+	release, reason, err := s.peers.acquire(pc)
+	if err != nil {
+		code := errCodeIdentity
+		if reason != "" {
+			s.rejections.WithLabelValues(reason).Inc()
+			code = errCodeLimit
+		}
+		s.writeError(encoder, code, err.Error())
+		return
+	}
+	defer func() {
+		inflight.Wait()
+		release()
+	}()
+
+	reader := &messageReader{r: c, limit: s.maxMessageSize}
+	decoder := json.NewDecoder(reader)
+	serve := func(dreq *decryptionRequest) bool {
+		inflight.Add(1)
+		ok := pool.submit(func() {
+			defer inflight.Done()
+			var resp *decryptionResponse
+			if dreq.WhoAmI {
+				resp = s.whoAmI(pc)
+			} else {
+				resp = s.decryptRequest(pc, dreq)
+			}
+			resp.ID = dreq.ID
+			encoder.write(resp)
+		})
+		if !ok {
+			inflight.Done()
+		}
+		return ok
+	}
+
+	dreq := new(decryptionRequest)
+	if !s.waitRequest(c) {
+		return
+	}
+	if err := decoder.Decode(dreq); err != nil {
+		if s.isDraining() {
 			return
 		}
+		s.writeError(encoder, s.rejectReadError(err), fmt.Sprintf("Could not unmarshal JSON: %v", err))
+		return
 	}
 
-	var dreq decryptionRequest
-	if err := decoder.Decode(&dreq); err != nil {
-		writeDecryptionError(encoder, 101, fmt.Sprintf("Could not unmarshal JSON: %v", err), "")
+	// version 2 clients send a single decryption request
+	if dreq.Hello == nil {
+		serve(dreq)
 		return
 	}
-	var dresp decryptionResponse
-	dresp.Secrets = make(map[string]string)
 
-	for k, v := range dreq.Ciphertexts {
-		decrypterType, b64, encryptedBlob := decrypter.SplitPALValue(v)
+	encoder.write(&decryptionResponse{Hello: s.hello()})
+	// version 3 clients may pipeline requests, whose responses are written
+	// as soon as they are ready and matched by ID
+	for {
+		dreq := new(decryptionRequest)
+		reader.reset()
+		if !s.waitRequest(c) {
+			return
+		}
+		if err := decoder.Decode(dreq); err == io.EOF || (err != nil && s.isDraining()) {
+			return
+		} else if err != nil {
+			s.writeError(encoder, s.rejectReadError(err), fmt.Sprintf("Could not unmarshal JSON: %v", err))
+			return
+		}
+		if !serve(dreq) {
+			return
+		}
+	}
+}
+
+// rejectReadError counts the failure to read a request if it was caused by a
+// limit, and returns its error code.
+func (s *Server) rejectReadError(err error) int {
+	if err == errMessageTooLarge {
+		s.rejections.WithLabelValues(rejectRequestSize).Inc()
+		return errCodeLimit
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		s.rejections.WithLabelValues(rejectTimeout).Inc()
+		return errCodeLimit
+	}
+	return errCodeGeneric
+}
+
+// writeError writes an error which is not related to any request, such as the
+// failure to identify the peer of a connection.
+func (s *Server) writeError(encoder *responseEncoder, code int, msg string) {
+	s.errors.WithLabelValues(strconv.Itoa(code), "").Inc()
+	writeDecryptionError(encoder, code, msg, "")
+}
+
+// tlsHandshakeTimeout bounds the time a TCP client may hold a connection slot
+// before authenticating.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// identifyPeer returns c together with the identity of its peer: its client
+// certificate for a TLS connection, and its process credentials otherwise.
+func identifyPeer(c net.Conn) (*conn, error) {
+	if tc, ok := c.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		pc, err := newTLSConn(tc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate peer %s: %v", c.RemoteAddr(), err)
+		}
+		tc.SetDeadline(time.Time{})
+		return pc, nil
+	}
+
+	ucred, err := getUcred(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve peer credential of the connection: %v", err)
+	}
+	// Pin the peer right away; it could otherwise exit and have its PID
+	// reused by a process in another container before we look it up.
+	pc, err := newConn(c, ucred)
+	if err == nil {
+		pc.securityContext, err = getPeerSecurityContext(c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify peer process %d: %v", ucred.Pid, err)
+	}
+	return pc, nil
+}
+
+// hello returns the hello a version 3 server answers with.
+func (s *Server) hello() *hello {
+	h := &hello{
+		Version:        protocolVersion,
+		MaxMessageSize: s.maxMessageSize,
+		Features:       []string{featurePipelining, featureSeal, featureWhoAmI},
+	}
+	st := s.state()
+	for scheme := range st.decrypters {
+		h.Schemes = append(h.Schemes, scheme)
+	}
+	sort.Strings(h.Schemes)
+	if st.labelsRetriever != nil {
+		h.Features = append(h.Features, featureLabels)
+	}
+	if s.auditSink != nil {
+		h.Features = append(h.Features, featureAudit)
+	}
+	return h
+}
+
+// decryptRequest authorizes the peer of c and decrypts the ciphertexts of
+// dreq. Every decision is recorded in the audit log.
+func (s *Server) decryptRequest(c *conn, dreq *decryptionRequest) (resp *decryptionResponse) {
+	trail := s.newAuditTrail(c, dreq)
+	st := s.acquireState()
+	defer st.release()
+	defer func(start time.Time) {
+		s.requestDuration.Observe(time.Since(start).Seconds())
+		if resp.Error != nil {
+			backend, _, _ := decrypter.SplitPALValue(dreq.Ciphertexts[resp.Error.Secret])
+			s.errors.WithLabelValues(strconv.Itoa(resp.Error.Code), backend).Inc()
+		}
+	}(time.Now())
+
+	if len(dreq.Ciphertexts) > s.maxSecrets {
+		s.rejections.WithLabelValues(rejectSecretCount).Inc()
+		return trail.fail(audit.Error, errCodeLimit, fmt.Sprintf("too many secrets in request: %d, the maximum is %d", len(dreq.Ciphertexts), s.maxSecrets), "")
+	}
+	for name, ciphertext := range dreq.Ciphertexts {
+		if len(ciphertext) > s.maxCiphertextSize {
+			s.rejections.WithLabelValues(rejectCiphertextSize).Inc()
+			return trail.fail(audit.Error, errCodeLimit, fmt.Sprintf("ciphertext of %s is too large: %d bytes, the maximum is %d", name, len(ciphertext), s.maxCiphertextSize), name)
+		}
+	}
+
+	var sealKey *[32]byte
+	if dreq.SealKey != "" {
+		var err error
+		if sealKey, err = parseSealKey(dreq.SealKey); err != nil {
+			return trail.fail(audit.Error, errCodeGeneric, err.Error(), "")
+		}
+	} else if st.requireSealing {
+		return trail.fail(audit.Deny, errCodeUnauthorized, "pald only returns sealed secrets, the client must support sealing", "")
+	}
+
+	identityStart := time.Now()
+	var identity *trustedlabels.Identity
+	if c.cert != nil {
+		// remote callers have no process to inspect, so their labels are
+		// always checked
+		var err error
+		identity, err = st.certRetriever.IdentityForCertificate(c.cert)
+		if err != nil {
+			if st.enforcement.strict() {
+				return trail.fail(audit.Error, errCodeIdentity, fmt.Sprintf("failed to get authorized labels: %v", err), "")
+			}
+			// the labels which are not enforced are still returned
+			log.Warningf("Failed to get authorized labels, granting none: %v", err)
+			identity = &trustedlabels.Identity{}
+		}
+		trail.identity = identity
+	} else if st.labelsRetriever != nil {
+		var err error
+		identity, err = st.labelsRetriever.IdentityForPID(int(c.Pid))
+		if err != nil {
+			if st.enforcement.strict() {
+				return trail.fail(audit.Error, errCodeIdentity, fmt.Sprintf("failed to get authorized labels: %v", err), "")
+			}
+			log.Warningf("Failed to get authorized labels, granting none: %v", err)
+			identity = &trustedlabels.Identity{}
+		}
+		if identity.Trace != nil {
+			for _, label := range identity.Trace.Labels() {
+				log.Debugf("Label %s for %s: %s", label, identity, identity.Trace.Explain(label))
+			}
+		}
+		if err := c.verifyPeer(); err != nil {
+			return trail.fail(audit.Error, errCodeIdentity, fmt.Sprintf("failed to get authorized labels: %v", err), "")
+		}
+		identity.Labels = st.grantSecurityContextLabels(identity.Labels, c.securityContext)
+		trail.identity = identity
+	}
+	s.identityDuration.Observe(time.Since(identityStart).Seconds())
+	if image, ok := s.lockdown.image(identity); ok {
+		return trail.fail(audit.Deny, errCodeUnauthorized, fmt.Sprintf("image %s is locked down", image), "")
+	}
+	refund, reason, err := s.rateLimitIdentity(c, identity, len(dreq.Ciphertexts))
+	if err != nil {
+		if reason == "" {
+			return trail.fail(audit.Error, errCodeIdentity, err.Error(), "")
+		}
+		s.rejections.WithLabelValues(reason).Inc()
+		return trail.fail(audit.Deny, errCodeLimit, err.Error(), "")
+	}
+	// only the secrets returned are charged, so that retrying a secret which
+	// cannot be returned does not exhaust the limits of everyone sharing them
+	var jobs []*decryptionJob
+	defer func() {
+		if resp.Error == nil {
+			return
+		}
+		refund()
+		for _, job := range jobs {
+			for _, label := range job.charged {
+				s.labelRate.refund(label, 1)
+			}
+		}
+	}()
+
+	keys := make([]string, 0, len(dreq.Ciphertexts))
+	for k := range dreq.Ciphertexts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	jobs = make([]*decryptionJob, len(keys))
+	for i, k := range keys {
+		decrypterType, b64, encryptedBlob := decrypter.SplitPALValue(dreq.Ciphertexts[k])
 		// Always base64-decode the ciphertext to get something parsable
 		data, err := base64.StdEncoding.DecodeString(encryptedBlob)
 		if err != nil {
-			writeDecryptionError(encoder, 101, fmt.Sprintf("Error decoding base64-encoded secret: %v", err), "")
+			return trail.fail(audit.Error, errCodeGeneric, fmt.Sprintf("Error decoding base64-encoded secret: %v", err), k)
+		}
+		d, ok := st.decrypters[decrypterType]
+		if !ok {
+			return trail.fail(audit.Error, errCodeGeneric, fmt.Sprintf("Unsupported secret type %q", decrypterType), k)
 		}
+		jobs[i] = &decryptionJob{backend: decrypterType, decrypter: d, data: data, base64: b64}
+	}
+
+	s.decryptJobs(st, c, identity, jobs)
+
+	// Merge in key order, reporting the failure of the first key that failed.
+	// A failure only cancels the decryptions of later keys, so the same
+	// request always gets the same error.
+	var dresp decryptionResponse
+	dresp.Secrets = make(map[string]string)
+	for i, job := range jobs {
+		trail.labels(keys[i], job.labels)
+	}
+	for i, job := range jobs {
+		s.checkFirstSeen(trail, identity, keys[i], job.labels)
+		for _, msg := range job.shadowed {
+			trail.alert(keys[i], "shadow mode: "+msg)
+		}
+	}
+	for i, job := range jobs {
+		if job.decision != "" {
+			return trail.fail(job.decision, job.code, job.msg, keys[i])
+		}
+		if job.canceled {
+			continue
+		}
+		dresp.Secrets[keys[i]] = job.value
+	}
+
+	// The labels were granted to the process we pinned at accept time; make
+	// sure that is still who we are replying to.
+	if err := c.verifyPeer(); err != nil {
+		return trail.fail(audit.Error, errCodeIdentity, err.Error(), "")
+	}
 
-		secret, err := s.decrypters[decrypterType].Decrypt(bytes.NewBuffer(data))
+	if sealKey != nil {
+		pub, sealed, err := sealSecrets(sealKey, dresp.Secrets)
 		if err != nil {
-			writeDecryptionError(encoder, 101, fmt.Sprintf("Failed to decrypt secret: %v", err), "")
-			return
+			return trail.fail(audit.Error, errCodeGeneric, fmt.Sprintf("failed to seal secrets: %v", err), "")
 		}
+		dresp.SealKey, dresp.Sealed, dresp.Secrets = pub, sealed, nil
+	}
 
-		for _, label := range secret.Labels {
+	// only count the secrets actually returned
+	for _, job := range jobs {
+		for _, label := range job.labels {
 			s.counter.WithLabelValues(label).Inc()
-			if _, ok := authorizedLabels[label]; !ok && s.labelsRetriever != nil {
-				writeDecryptionError(encoder, 101, fmt.Sprintf("Error unauthorized label: %s, required %v", label, authorizedLabels), "")
-				return
+		}
+	}
+	trail.allow()
+	return &dresp
+}
+
+// rateLimitIdentity takes n decryptions from the rate limits of the container
+// and of the image of identity, of the certificate of a remote peer of c, or of
+// the container of the peer of c if labels are disabled. If one of them is
+// exceeded, it returns the reason of the rejection, one of the reject
+// constants, with an error. Otherwise, the error is the failure to find the
+// container, and refund gives the decryptions back.
+func (s *Server) rateLimitIdentity(c *conn, identity *trustedlabels.Identity, n int) (refund func(), reason string, err error) {
+	kind, container, image := "container", "", ""
+	switch {
+	case c.cert != nil:
+		// a certificate stands for the container of a remote caller
+		kind, container = "certificate", certificateRateKey(identity, c.cert)
+	case identity != nil:
+		container = identity.ContainerID
+		if image = identity.ImageDigest; image == "" {
+			image = identity.ImageName
+		}
+	case s.containerRate != nil && c.Ucred != nil:
+		if container, err = procContainerID(int(c.Pid)); err != nil {
+			return nil, "", fmt.Errorf("failed to find the container of peer process %d: %v", c.Pid, err)
+		}
+	}
+	if container != "" && !s.containerRate.allow(container, n) {
+		return nil, rejectContainerRate, fmt.Errorf("rate limit exceeded for %s %s", kind, container)
+	}
+	if image != "" && !s.imageRate.allow(image, n) {
+		s.containerRate.refund(container, n)
+		return nil, rejectImageRate, fmt.Errorf("rate limit exceeded for image %s", image)
+	}
+	return func() {
+		s.containerRate.refund(container, n)
+		s.imageRate.refund(image, n)
+	}, "", nil
+}
+
+// certificateRateKey returns the key of the rate limit of a remote caller:
+// its certificate identity, or the fingerprint of cert if it has none.
+func certificateRateKey(identity *trustedlabels.Identity, cert *x509.Certificate) string {
+	if identity != nil && identity.CertificateID != "" {
+		return identity.CertificateID
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// checkFirstSeen raises an alert for each of the labels of secret that the
+// image digest of identity requests for the first time, whether or not it is
+// granted them.
+func (s *Server) checkFirstSeen(trail *auditTrail, identity *trustedlabels.Identity, secret string, labels []string) {
+	if s.tripwire == nil || identity == nil || identity.ImageDigest == "" {
+		return
+	}
+	for _, label := range labels {
+		first, err := s.tripwire.observe(identity.ImageDigest, label)
+		if err != nil {
+			log.Errorf("Tripwire: %v", err)
+		}
+		if !first {
+			continue
+		}
+		msg := fmt.Sprintf("label %s requested for the first time by image %s", label, identity.ImageDigest)
+		log.Warningf("%s for %s", msg, identity)
+		s.firstSeen.WithLabelValues(label).Inc()
+		trail.alert(secret, msg)
+	}
+}
+
+// A decryptionJob is the decryption and authorization of a single secret of a
+// request.
+type decryptionJob struct {
+	backend   string
+	decrypter decrypter.Decrypter
+	data      []byte
+	base64    bool
+
+	// charged are the labels whose rate limit was charged for the secret.
+	charged []string
+	// cancel is closed when a secret before this one in the request fails,
+	// and canceled is set if the job was not run because of it.
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	canceled   bool
+	labels     []string
+	value      string
+	// decision, code and msg are set if the secret may not be returned.
+	decision string
+	code     int
+	msg      string
+	// shadowed holds why the secret would be denied if its labels were
+	// enforced.
+	shadowed []string
+}
+
+// decryptJobs runs jobs concurrently, at most s.decryptConcurrency at a time
+// and within the concurrency limit of each backend. When a job fails, the jobs
+// after it that have not started yet are canceled, since the request fails
+// with the error of the first failing job anyway. The jobs before it still
+// run, so that the first failing job does not depend on scheduling.
+func (s *Server) decryptJobs(st *serverState, c *conn, identity *trustedlabels.Identity, jobs []*decryptionJob) {
+	var (
+		wg    sync.WaitGroup
+		limit = make(chan struct{}, s.decryptConcurrency)
+	)
+	for _, job := range jobs {
+		job.cancel = make(chan struct{})
+	}
+	for i, job := range jobs {
+		i, job := i, job
+		select {
+		case limit <- struct{}{}:
+		case <-job.cancel:
+			job.canceled = true
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-limit }()
+			s.decryptJob(st, c, identity, job)
+			if job.decision != "" {
+				for _, later := range jobs[i+1:] {
+					later.cancelOnce.Do(func() { close(later.cancel) })
+				}
 			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Server) decryptJob(st *serverState, c *conn, identity *trustedlabels.Identity, job *decryptionJob) {
+	if limit, ok := st.backendLimits[job.backend]; ok {
+		select {
+		case limit <- struct{}{}:
+			defer func() { <-limit }()
+		case <-job.cancel:
+			job.canceled = true
+			return
 		}
+	}
+	select {
+	case <-job.cancel:
+		job.canceled = true
+		return
+	default:
+	}
 
-		// NB - this assumes all secrets have been safely encoded for
-		// stringification, but the client is guaranteeing that for us.
-		dresp.Secrets[k] = string(secret.Value)
-		if b64 {
-			dresp.Secrets[k] = "base64:" + dresp.Secrets[k]
+	start := time.Now()
+	secret, err := job.decrypter.Decrypt(bytes.NewBuffer(job.data))
+	s.backendDuration.WithLabelValues(job.backend).Observe(time.Since(start).Seconds())
+	if err != nil {
+		job.decision, job.code, job.msg = audit.Error, errCodeDecrypt, fmt.Sprintf("Failed to decrypt secret: %v", err)
+		return
+	}
+	job.labels = secret.Labels
+	if reason := s.revocations.revoked(job.data, secret); reason != "" {
+		job.decision, job.code, job.msg = audit.Deny, errCodeRevoked, fmt.Sprintf("Error revoked secret: %s", reason)
+		return
+	}
+
+	for _, label := range secret.Labels {
+		if s.lockdown.label(label) {
+			s.deny(identity, label)
+			job.decision, job.code, job.msg = audit.Deny, errCodeUnauthorized, fmt.Sprintf("Error unauthorized label: %s is locked down", label)
+			return
 		}
+		mode := st.enforcement.mode(label)
+		var msg string
+		if mode == EnforcementOff {
+			// the label policies are configured on their own, so they are
+			// enforced even if the labels of the caller are not checked
+			msg, mode = st.authorizePolicy(c, label), EnforcementEnforce
+		} else {
+			msg = st.authorizeLabel(c, identity, label)
+		}
+		if msg != "" && mode == EnforcementShadow {
+			s.shadowDeny(identity, label, msg)
+			job.shadowed = append(job.shadowed, msg)
+		} else if msg != "" {
+			s.deny(identity, label)
+			job.decision, job.code, job.msg = audit.Deny, errCodeUnauthorized, msg
+			return
+		}
+		if !s.labelRate.allow(label, 1) {
+			s.rejections.WithLabelValues(rejectLabelRate).Inc()
+			job.decision, job.code, job.msg = audit.Deny, errCodeLimit, fmt.Sprintf("rate limit exceeded for label %s", label)
+			return
+		}
+		job.charged = append(job.charged, label)
 	}
 
-	if err := encoder.Encode(dresp); err != nil {
-		log.Errorf("Failed to marshal decryption response: %v", err)
+	// NB - this assumes all secrets have been safely encoded for
+	// stringification, but the client is guaranteeing that for us.
+	job.value = string(secret.Value)
+	if job.base64 {
+		job.value = "base64:" + job.value
+	}
+}
+
+// authorizeLabel checks that label is granted to identity, if labels are
+// enabled, and that the peer of c satisfies the policy of label. It returns why
+// label is denied, or an empty string if it is authorized.
+func (st *serverState) authorizeLabel(c *conn, identity *trustedlabels.Identity, label string) string {
+	if identity != nil && !identity.HasLabel(label) {
+		msg := fmt.Sprintf("Error unauthorized label: %s, required %v for %s", label, identity.Labels, identity)
+		if identity.Trace != nil {
+			msg += fmt.Sprintf(" (%s)", identity.Trace.Explain(label))
+		}
+		return msg
+	}
+	return st.authorizePolicy(c, label)
+}
+
+// authorizePolicy checks that the peer of c satisfies the policy of label, if
+// any. It returns why label is denied, or an empty string if it is authorized.
+func (st *serverState) authorizePolicy(c *conn, label string) string {
+	if policy, ok := st.labelPolicies[label]; ok {
+		if err := policy.authorize(c); err != nil {
+			return fmt.Sprintf("Error unauthorized label: %s, %v", label, err)
+		}
+	}
+	return ""
+}
+
+// grantSecurityContextLabels returns labels together with the labels that the
+// configuration grants to the LSM label ctx of a peer.
+func (st *serverState) grantSecurityContextLabels(labels map[string]struct{}, ctx *securityContext) map[string]struct{} {
+	if ctx == nil || len(st.contextLabels) == 0 {
+		return labels
+	}
+	granted := make(map[string]struct{}, len(labels))
+	for label := range labels {
+		granted[label] = struct{}{}
+	}
+	for _, key := range ctx.keys() {
+		for _, label := range st.contextLabels[key] {
+			granted[label] = struct{}{}
+		}
 	}
+	return granted
 }
 
-func writeDecryptionError(w *json.Encoder, code int, msg string, secret string) {
+func writeDecryptionError(w interface {
+	Encode(v interface{}) error
+}, code int, msg string, secret string) {
 	log.Error(msg)
 	resp := decryptionResponse{
 		Error: &decryptionError{
-			Code:    101,
+			Code:    code,
 			Message: msg,
 			Secret:  secret,
 		},
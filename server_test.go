@@ -1,8 +1,43 @@
 package pal
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/cloudflare/pal/audit"
+	"github.com/cloudflare/pal/decrypter"
+	"github.com/cloudflare/pal/trustedlabels"
+	"github.com/joshlf/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/openpgp"
 
 	"github.com/cloudflare/redoctober/cryptor"
 )
@@ -71,3 +106,1115 @@ var (
 		"Signature": "aaaaaaaaaaaaaaaaaaaaaaaaaaa="
 	}`)
 )
+
+func TestLoadServerConfigEntry(t *testing.T) {
+	config, err := LoadServerConfigEntry(bytes.NewBufferString(testServerYAML), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &CompositeConfig{
+		Mode: "intersect",
+		Sources: []*RetrieverConfig{
+			{Retriever: "docker"},
+			{
+				Name:      "either",
+				Retriever: "composite",
+				Composite: &CompositeConfig{
+					Mode: "union",
+					Sources: []*RetrieverConfig{
+						{Retriever: "docker"},
+						{Retriever: "security_context", Labels: map[string][]string{"selinux_type:myapp_t": {"db-prod"}}},
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(config.LabelsComposite, want) {
+		t.Errorf("want labels_composite %+v, got %+v", want, config.LabelsComposite)
+	}
+	if got := config.LabelPolicies["db-prod"]; got == nil || !reflect.DeepEqual(got.Exe, []string{"/usr/bin/myapp"}) {
+		t.Errorf("want label policy for db-prod, got %+v", got)
+	}
+
+	config.LabelsComposite.Sources[1].Composite.Sources[1].Name = "docker"
+	if _, err := NewServer(config); err == nil || !strings.HasPrefix(err.Error(), "duplicate composite labels retriever source docker") {
+		t.Errorf("want duplicate source error, got %v", err)
+	}
+	config.LabelsComposite.Sources[1].Composite.Sources[1].Name = ""
+
+	config.LabelsComposite.Mode = "xor"
+	if _, err := NewServer(config); err == nil || err.Error() != `invalid composite labels retriever mode "xor"` {
+		t.Errorf("want invalid mode error, got %v", err)
+	}
+
+	if len(config.AuditSinks) != 1 || !reflect.DeepEqual(config.AuditSinks[0].Decisions, []string{"deny"}) {
+		t.Errorf("want a webhook audit sink for denials, got %+v", config.AuditSinks)
+	}
+	config.LabelsEnabled = false
+	config.AuditSinks[0].Type = "kafka"
+	if _, err := NewServer(config); err == nil || err.Error() != `invalid audit sink type "kafka"` {
+		t.Errorf("want invalid audit sink error, got %v", err)
+	}
+}
+
+var testServerYAML = `
+prod:
+  pgp_keyring_path: testdata/secring.gpg
+  pgp_passphrase: paltest
+  labels_enabled: true
+  labels_retriever: composite
+  labels_composite:
+    mode: intersect
+    sources:
+    - retriever: docker
+    - name: either
+      retriever: composite
+      composite:
+        mode: union
+        sources:
+        - retriever: docker
+        - retriever: security_context
+          labels:
+            selinux_type:myapp_t: [db-prod]
+  label_policies:
+    db-prod:
+      exe: [/usr/bin/myapp]
+  audit_sinks:
+  - type: webhook
+    address: https://siem.example.com/pal
+    queue_dir: /var/lib/pald/webhook
+    decisions: [deny]
+`
+
+// mustPGPServer returns a Server that only decrypts PGP secrets with the test
+// keyring and which uses retriever, if not nil, to authorize labels.
+func mustPGPServer(t testing.TB, retriever trustedlabels.Retriever) *Server {
+	s, err := NewServer(&ServerConfigEntry{
+		PGPKeyRingPath: "testdata/secring.gpg",
+		PGPPassphrase:  "paltest",
+	})
+	testutil.MustPrefix(t, "could not create pald server", err)
+	s.state().labelsRetriever = retriever
+	return s
+}
+
+// mustPGPEncrypt returns the PAL value of a PGP secret holding plaintext and
+// carrying the given labels.
+func mustPGPEncrypt(t testing.TB, plaintext string, labels ...string) string {
+	f, err := os.Open("testdata/pubring.gpg")
+	testutil.MustPrefix(t, "could not open public keyring", err)
+	defer f.Close()
+	keys, err := openpgp.ReadKeyRing(f)
+	testutil.MustPrefix(t, "could not read public keyring", err)
+
+	secret, err := json.Marshal(&decrypter.Secret{Labels: labels, Value: []byte(plaintext)})
+	testutil.MustPrefix(t, "could not marshal secret", err)
+	buf := new(bytes.Buffer)
+	w, err := openpgp.Encrypt(buf, keys, nil, nil, decrypter.NewPGPPacketConfig("aes256", "sha256"))
+	testutil.MustPrefix(t, "could not encrypt secret", err)
+	_, err = w.Write(secret)
+	testutil.MustPrefix(t, "could not encrypt secret", err)
+	testutil.MustPrefix(t, "could not encrypt secret", w.Close())
+	return "pgp:" + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestServerUnauthorizedLabel(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, trustedlabels.NewUnion(
+		trustedlabels.Source{Name: "docker", Retriever: mockLabelsRetriever},
+		trustedlabels.Source{Name: "k8s", Retriever: trustedlabels.NewMock(nil)},
+	))
+	go server.ServeRPC(listener)
+
+	config := &ConfigEntry{
+		Envs: map[string]string{
+			"FOO":    mustPGPEncrypt(t, "foo", "app-foo"),
+			"SECRET": mustPGPEncrypt(t, "secret", "app-foo", "db-prod"),
+		},
+	}
+	err := newClientV2(config, listener.Addr().String()).Decrypt()
+	if err == nil || !strings.Contains(err.Error(), "Error unauthorized label: db-prod") ||
+		!strings.HasSuffix(err.Error(), "(denied by docker, k8s)") {
+		t.Errorf("want unauthorized label error with trace, got %v", err)
+	}
+
+	delete(config.Envs, "SECRET")
+	client := newClientV2(config, listener.Addr().String())
+	testutil.MustPrefix(t, "could not decrypt secrets", client.Decrypt())
+	if got := client.config.Envs["FOO"]; got != "foo" {
+		t.Errorf("want secret %q, got %q", "foo", got)
+	}
+}
+
+func TestServerAuditLog(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, mockLabelsRetriever)
+	auditLog, err := audit.Open(filepath.Join(tempdir, "audit.log"))
+	testutil.MustPrefix(t, "could not open audit log", err)
+	server.auditSink = auditLog
+	go server.ServeRPC(listener)
+
+	config := &ConfigEntry{
+		Envs: map[string]string{
+			"FOO": mustPGPEncrypt(t, "foo", "app-foo"),
+		},
+	}
+	testutil.MustPrefix(t, "could not decrypt secrets", newClientV2(config, listener.Addr().String()).Decrypt())
+	config.Envs = map[string]string{
+		"FOO":    mustPGPEncrypt(t, "foo", "app-foo"),
+		"SECRET": mustPGPEncrypt(t, "secret", "db-prod"),
+	}
+	if err := newClientV2(config, listener.Addr().String()).Decrypt(); err == nil {
+		t.Fatal("want unauthorized label error")
+	}
+
+	f, err := os.Open(filepath.Join(tempdir, "audit.log"))
+	testutil.MustPrefix(t, "could not open audit log", err)
+	defer f.Close()
+	var got []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec audit.Record
+		testutil.MustPrefix(t, "could not parse audit record", json.Unmarshal(scanner.Bytes(), &rec))
+		if rec.PID != os.Getpid() || rec.Backend != "pgp" {
+			t.Errorf("want pid %d and backend pgp, got %+v", os.Getpid(), rec)
+		}
+		got = append(got, rec.Secret+":"+rec.Decision)
+	}
+	want := []string{"FOO:allow", "FOO:deny", "SECRET:deny"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want audit records %v, got %v", want, got)
+	}
+
+	_, err = f.Seek(0, 0)
+	testutil.MustPrefix(t, "could not rewind audit log", err)
+	n, _, err := audit.Verify(f)
+	if n != 3 || err != nil {
+		t.Errorf("want 3 valid audit records, got %d, %v", n, err)
+	}
+}
+
+// serveV2 serves l like a version 2 server, which handles a hello as an empty
+// decryption request.
+func serveV2(s *Server, l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			ucred, err := getUcred(c)
+			if err != nil {
+				return
+			}
+			pc, err := newConn(c, ucred)
+			if err != nil {
+				return
+			}
+			var dreq decryptionRequest
+			if err := json.NewDecoder(c).Decode(&dreq); err != nil {
+				return
+			}
+			dreq.Hello = nil
+			json.NewEncoder(c).Encode(s.decryptRequest(pc, &dreq))
+		}()
+	}
+}
+
+func TestServerProtocolVersions(t *testing.T) {
+	server := mustPGPServer(t, nil)
+	// a scheme unknown to the client, which must learn it from the hello
+	server.state().decrypters["vault"] = server.state().decrypters["pgp"]
+	secret := mustPGPEncrypt(t, "foo")
+	newConfig := func() *ConfigEntry {
+		return &ConfigEntry{
+			Envs: map[string]string{
+				"FOO": secret,
+				"BAR": "vault:" + strings.TrimPrefix(secret, "pgp:"),
+			},
+		}
+	}
+
+	tests := []struct {
+		Serve func(*Server, net.Listener)
+		Dials int
+		BAR   string
+	}{
+		{Serve: func(s *Server, l net.Listener) { s.ServeRPC(l) }, Dials: 1, BAR: "foo"},
+		// hello, then one connection per request
+		{Serve: serveV2, Dials: 3, BAR: newConfig().Envs["BAR"]},
+	}
+	for i, test := range tests {
+		listener, tempdir := mustListenUnixSocket(t)
+		go test.Serve(server, listener)
+
+		var dials int
+		client := newClientV2(newConfig(), listener.Addr().String())
+		client.dialFunc = func(network, addr string) (net.Conn, error) {
+			dials++
+			return net.Dial(network, addr)
+		}
+		testutil.MustPrefix(t, "could not decrypt secrets", client.Decrypt())
+		if got := client.config.Envs["FOO"]; got != "foo" {
+			t.Errorf("%d: want FOO %q, got %q", i, "foo", got)
+		}
+		if got := client.config.Envs["BAR"]; got != test.BAR {
+			t.Errorf("%d: want BAR %q, got %q", i, test.BAR, got)
+		}
+		if dials != test.Dials {
+			t.Errorf("%d: want %d connections, got %d", i, test.Dials, dials)
+		}
+
+		listener.Close()
+		os.RemoveAll(tempdir)
+	}
+
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+	server.maxMessageSize = 512
+	go server.ServeRPC(listener)
+	err := newClientV2(newConfig(), listener.Addr().String()).Decrypt()
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum message size of pald (512 bytes)") {
+		t.Errorf("want maximum message size error, got %v", err)
+	}
+}
+
+func TestServerPipelining(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, nil)
+	server.rpcWorkers = 2
+	go server.ServeRPC(listener)
+
+	c, err := net.Dial("unix", listener.Addr().String())
+	testutil.MustPrefix(t, "could not connect to pald", err)
+	defer c.Close()
+	encoder, decoder := json.NewEncoder(c), json.NewDecoder(c)
+	testutil.MustPrefix(t, "could not send hello", encoder.Encode(&decryptionRequest{Hello: &hello{Version: protocolVersion}}))
+	var dresp decryptionResponse
+	testutil.MustPrefix(t, "could not read hello", decoder.Decode(&dresp))
+	if dresp.Hello == nil || !dresp.Hello.hasFeature(featurePipelining) {
+		t.Fatalf("want a hello advertising pipelining, got %+v", dresp)
+	}
+
+	const n = 8
+	for id := uint64(1); id <= n; id++ {
+		dreq := &decryptionRequest{
+			ID:          id,
+			Ciphertexts: map[string]string{"FOO": mustPGPEncrypt(t, fmt.Sprint(id))},
+		}
+		testutil.MustPrefix(t, "could not send request", encoder.Encode(dreq))
+	}
+	seen := make(map[uint64]bool)
+	for i := 0; i < n; i++ {
+		var dresp decryptionResponse
+		testutil.MustPrefix(t, "could not read response", decoder.Decode(&dresp))
+		if dresp.Error != nil || dresp.Secrets["FOO"] != fmt.Sprint(dresp.ID) || seen[dresp.ID] {
+			t.Errorf("unexpected response %+v", dresp)
+		}
+		seen[dresp.ID] = true
+	}
+}
+
+// BenchmarkServeRPCContainerStart measures the throughput of pald when many
+// containers start at once, each of them connecting to decrypt its secrets.
+func BenchmarkServeRPCContainerStart(b *testing.B) {
+	listener, tempdir := mustListenUnixSocket(b)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(b, mockLabelsRetriever)
+	go server.ServeRPC(listener)
+	secret := mustPGPEncrypt(b, "foo", "app-foo")
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			config := &ConfigEntry{
+				Envs:  map[string]string{"FOO": secret},
+				Files: map[string]string{"/foo": secret},
+			}
+			if err := newClientV2(config, listener.Addr().String()).Decrypt(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkServeRPCPipelined measures the throughput of a single connection
+// pipelining its requests.
+func BenchmarkServeRPCPipelined(b *testing.B) {
+	listener, tempdir := mustListenUnixSocket(b)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(b, mockLabelsRetriever)
+	go server.ServeRPC(listener)
+	secret := mustPGPEncrypt(b, "foo", "app-foo")
+
+	c, err := net.Dial("unix", listener.Addr().String())
+	testutil.MustPrefix(b, "could not connect to pald", err)
+	defer c.Close()
+	encoder, decoder := json.NewEncoder(c), json.NewDecoder(c)
+	testutil.MustPrefix(b, "could not send hello", encoder.Encode(&decryptionRequest{Hello: &hello{Version: protocolVersion}}))
+	testutil.MustPrefix(b, "could not read hello", decoder.Decode(new(decryptionResponse)))
+
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			dreq := &decryptionRequest{ID: uint64(i + 1), Ciphertexts: map[string]string{"FOO": secret}}
+			if err := encoder.Encode(dreq); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		var dresp decryptionResponse
+		if err := decoder.Decode(&dresp); err != nil || dresp.Error != nil {
+			b.Fatalf("unexpected response %+v: %v", dresp, err)
+		}
+	}
+}
+
+// slowDecrypter returns secrets whose labels are their ciphertexts, after a
+// delay, and tracks the number of concurrent decryptions.
+type slowDecrypter struct {
+	mu             sync.Mutex
+	calls, running int
+	maxRunning     int
+	delay          time.Duration
+}
+
+func (d *slowDecrypter) Decrypt(r io.Reader) (*decrypter.Secret, error) {
+	d.mu.Lock()
+	d.calls++
+	d.running++
+	if d.running > d.maxRunning {
+		d.maxRunning = d.running
+	}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.running--
+		d.mu.Unlock()
+	}()
+
+	time.Sleep(d.delay)
+	label, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &decrypter.Secret{Labels: []string{string(label)}, Value: label}, nil
+}
+
+func TestServerConcurrentDecryption(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	slow := &slowDecrypter{delay: 50 * time.Millisecond}
+	server := mustPGPServer(t, mockLabelsRetriever)
+	server.state().decrypters["slow"] = slow
+	server.decryptConcurrency = 4
+	server.state().backendLimits["slow"] = make(chan struct{}, 3)
+	go server.ServeRPC(listener)
+
+	config := &ConfigEntry{Envs: make(map[string]string)}
+	for i := 0; i < 12; i++ {
+		config.Envs[fmt.Sprintf("FOO%d", i)] = "slow:" + base64.StdEncoding.EncodeToString([]byte("app-foo"))
+	}
+	start := time.Now()
+	testutil.MustPrefix(t, "could not decrypt secrets", newClientV2(config, listener.Addr().String()).Decrypt())
+	if elapsed := time.Since(start); elapsed > 12*slow.delay/2 {
+		t.Errorf("want concurrent decryptions, took %v", elapsed)
+	}
+	if slow.maxRunning != 3 {
+		t.Errorf("want at most 3 concurrent decryptions by the backend, got %d", slow.maxRunning)
+	}
+	for k, v := range config.Envs {
+		if v != "app-foo" {
+			t.Errorf("want %s decrypted, got %q", k, v)
+		}
+	}
+
+	// the first unauthorized secret cancels the decryptions not started yet
+	slow.calls = 0
+	config.Envs["A"] = "slow:" + base64.StdEncoding.EncodeToString([]byte("db-prod"))
+	err := newClientV2(config, listener.Addr().String()).Decrypt()
+	if err == nil || !strings.Contains(err.Error(), "Error unauthorized label: db-prod") {
+		t.Errorf("want unauthorized label error, got %v", err)
+	}
+	if slow.calls >= len(config.Envs) {
+		t.Errorf("want the remaining decryptions canceled, got %d of %d", slow.calls, len(config.Envs))
+	}
+}
+
+func TestServerFirstFailure(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	slow := &slowDecrypter{}
+	server := mustPGPServer(t, mockLabelsRetriever)
+	server.state().decrypters["slow"] = slow
+	limit := make(chan struct{}, 1)
+	server.state().backendLimits["slow"] = limit
+	go server.ServeRPC(listener)
+
+	// A waits for its backend while B fails, but B comes after A, so the
+	// error of A is reported
+	config := &ConfigEntry{Envs: map[string]string{
+		"A": "slow:" + base64.StdEncoding.EncodeToString([]byte("db-prod")),
+		"B": "pgp:" + base64.StdEncoding.EncodeToString([]byte("garbage")),
+	}}
+	limit <- struct{}{}
+	time.AfterFunc(50*time.Millisecond, func() { <-limit })
+	err := newClientV2(config, listener.Addr().String()).Decrypt()
+	derr, ok := err.(*decryptionError)
+	if !ok || derr.Code != errCodeUnauthorized || derr.Secret != "A" {
+		t.Errorf("want unauthorized label error for A, got %v", err)
+	}
+}
+
+// mustWriteCertificate writes a certificate for template and its key to
+// dir/name.crt and dir/name.key, signed by parent or self-signed if parent is
+// nil, and returns them.
+func mustWriteCertificate(t *testing.T, dir, name string, template *x509.Certificate,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.MustPrefix(t, "could not generate key", err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	testutil.MustPrefix(t, "could not create certificate", err)
+	cert, err := x509.ParseCertificate(der)
+	testutil.MustPrefix(t, "could not parse certificate", err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	testutil.MustPrefix(t, "could not marshal key", err)
+
+	err = ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	testutil.MustPrefix(t, "could not write certificate", err)
+	err = ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	testutil.MustPrefix(t, "could not write key", err)
+	return cert, key
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := testutil.MustTempDir(t, "", "pal-tls")
+	defer os.RemoveAll(dir)
+
+	ca, caKey := mustWriteCertificate(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "pal test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	mustWriteCertificate(t, dir, "server", &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	spiffeID, _ := url.Parse("spiffe://example.org/vm/db/1")
+	mustWriteCertificate(t, dir, "client", &x509.Certificate{
+		URIs:        []*url.URL{spiffeID},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	config := &ServerConfigEntry{
+		PGPKeyRingPath:    "testdata/secring.gpg",
+		PGPPassphrase:     "paltest",
+		TLSCert:           filepath.Join(dir, "server.crt"),
+		TLSKey:            filepath.Join(dir, "server.key"),
+		TLSClientCA:       filepath.Join(dir, "ca.crt"),
+		CertificateLabels: map[string][]string{"spiffe://example.org/vm/db/*": {"db-prod", "db-local"}},
+		LabelPolicies:     map[string]*LabelPolicy{"db-local": {Exe: []string{"/usr/bin/myapp"}}},
+	}
+	server, err := NewServer(config)
+	testutil.MustPrefix(t, "could not create pald server", err)
+	listener, err := ListenTLS("127.0.0.1:0", config)
+	testutil.MustPrefix(t, "could not listen", err)
+	defer listener.Close()
+	go server.ServeRPC(listener)
+
+	tlsConfig, err := NewClientTLSConfig(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt"))
+	testutil.MustPrefix(t, "could not load client TLS configuration", err)
+	newClient := func(config *ConfigEntry) *clientV2 {
+		c := newClientV2(config, listener.Addr().String())
+		c.dialFunc = func(_, _ string) (net.Conn, error) {
+			return tls.Dial("tcp", listener.Addr().String(), tlsConfig)
+		}
+		return c
+	}
+
+	client := newClient(&ConfigEntry{Envs: map[string]string{"DB": mustPGPEncrypt(t, "db", "db-prod")}})
+	testutil.MustPrefix(t, "could not decrypt secrets", client.Decrypt())
+	if got := client.config.Envs["DB"]; got != "db" {
+		t.Errorf("want secret %q, got %q", "db", got)
+	}
+
+	for label, want := range map[string]string{
+		"app-foo":  "Error unauthorized label: app-foo, required map[db-local:{} db-prod:{}] for certificate spiffe://example.org/vm/db/1",
+		"db-local": "peer is not a local process",
+	} {
+		err := newClient(&ConfigEntry{Envs: map[string]string{"FOO": mustPGPEncrypt(t, "foo", "db-prod", label)}}).Decrypt()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("want %q, got %v", want, err)
+		}
+	}
+
+	// a client without a certificate is rejected during the handshake
+	tlsConfig = &tls.Config{RootCAs: tlsConfig.RootCAs}
+	if err := newClient(&ConfigEntry{}).Decrypt(); err == nil {
+		t.Error("want a client without certificate to be rejected")
+	}
+}
+
+func TestServerSealing(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, nil)
+	server.state().requireSealing = true
+	go server.ServeRPC(listener)
+
+	secret := mustPGPEncrypt(t, "foo")
+	roundTrip := func(dreq *decryptionRequest) *decryptionResponse {
+		c, err := net.Dial("unix", listener.Addr().String())
+		testutil.MustPrefix(t, "could not connect to pald", err)
+		defer c.Close()
+		testutil.MustPrefix(t, "could not send request", json.NewEncoder(c).Encode(dreq))
+		dresp := new(decryptionResponse)
+		testutil.MustPrefix(t, "could not read response", json.NewDecoder(c).Decode(dresp))
+		return dresp
+	}
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	testutil.MustPrefix(t, "could not generate seal key", err)
+	dresp := roundTrip(&decryptionRequest{
+		Ciphertexts: map[string]string{"FOO": secret},
+		SealKey:     base64.StdEncoding.EncodeToString(pub[:]),
+	})
+	if dresp.Error != nil || len(dresp.Secrets) != 0 || dresp.SealKey == "" {
+		t.Fatalf("want only sealed secrets, got %+v", dresp)
+	}
+	secrets, err := openSecrets(priv, dresp.SealKey, dresp.Sealed)
+	if err != nil || secrets["FOO"] != "foo" {
+		t.Errorf("want sealed secret %q, got %v, %v", "foo", secrets, err)
+	}
+	// a secret sealed to another key cannot be opened
+	_, other, _ := box.GenerateKey(rand.Reader)
+	if _, err := openSecrets(other, dresp.SealKey, dresp.Sealed); err == nil {
+		t.Error("want an error opening secrets with another key")
+	}
+
+	dresp = roundTrip(&decryptionRequest{Ciphertexts: map[string]string{"FOO": secret}})
+	if dresp.Error == nil || len(dresp.Secrets) != 0 {
+		t.Errorf("want unsealed requests rejected, got %+v", dresp)
+	}
+	dresp = roundTrip(&decryptionRequest{Ciphertexts: map[string]string{"FOO": secret}, SealKey: "Zm9v"})
+	if dresp.Error == nil || !strings.Contains(dresp.Error.Message, "invalid seal key") {
+		t.Errorf("want invalid seal key error, got %+v", dresp)
+	}
+
+	// the client negotiates sealing with the server
+	client := newClientV2(&ConfigEntry{Envs: map[string]string{"FOO": secret}}, listener.Addr().String())
+	testutil.MustPrefix(t, "could not decrypt secrets", client.Decrypt())
+	if got := client.config.Envs["FOO"]; got != "foo" {
+		t.Errorf("want secret %q, got %q", "foo", got)
+	}
+}
+
+func TestServerV1Authorization(t *testing.T) {
+	server := mustPGPServer(t, mockLabelsRetriever)
+	server.state().decrypters["ro"] = &slowDecrypter{}
+	serve := func(l net.Listener) {
+		(&http.Server{Handler: server, ConnContext: server.ConnContext}).Serve(l)
+	}
+	roSecret := func(label string) string {
+		return "ro:" + base64.StdEncoding.EncodeToString([]byte(label))
+	}
+
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+	go serve(listener)
+
+	client := newClientV1(&ConfigEntry{Envs: map[string]string{"FOO": roSecret("app-foo")}}, listener.Addr().String())
+	testutil.MustPrefix(t, "could not decrypt secrets", client.Decrypt())
+	if got := client.config.Envs["FOO"]; got != "app-foo" {
+		t.Errorf("want secret %q, got %q", "app-foo", got)
+	}
+
+	err := newClientV1(&ConfigEntry{Envs: map[string]string{"SECRET": roSecret("db-prod")}}, listener.Addr().String()).Decrypt()
+	if err == nil || !strings.Contains(err.Error(), "Error unauthorized label: db-prod") {
+		t.Errorf("want unauthorized label error, got %v", err)
+	}
+
+	server.state().disableV1 = true
+	err = newClientV1(&ConfigEntry{Envs: map[string]string{"FOO": roSecret("app-foo")}}, listener.Addr().String()).Decrypt()
+	if err == nil || !strings.Contains(err.Error(), "410 Gone") {
+		t.Errorf("want the legacy protocol disabled, got %v", err)
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	server := mustPGPServer(t, nil)
+	if read, write := server.Timeouts(); read != defaultReadTimeout || write != defaultWriteTimeout {
+		t.Errorf("want default timeouts %v and %v, got %v and %v", defaultReadTimeout, defaultWriteTimeout, read, write)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, nil)
+	server.maxSecrets = 1
+	server.maxCiphertextSize = 4096
+	server.peers = newPeerLimiter(1, 0)
+	go server.ServeRPC(listener)
+
+	decrypt := func(c net.Conn, ciphertexts map[string]string) *decryptionError {
+		testutil.MustPrefix(t, "could not send request", json.NewEncoder(c).Encode(&decryptionRequest{Ciphertexts: ciphertexts}))
+		var dresp decryptionResponse
+		testutil.MustPrefix(t, "could not read response", json.NewDecoder(c).Decode(&dresp))
+		return dresp.Error
+	}
+	dial := func() net.Conn {
+		c, err := net.Dial("unix", listener.Addr().String())
+		testutil.MustPrefix(t, "could not connect to pald", err)
+		return c
+	}
+
+	secret := mustPGPEncrypt(t, "foo")
+	tests := []struct {
+		ciphertexts map[string]string
+		want        string
+	}{
+		{map[string]string{"FOO": secret, "BAR": secret}, "too many secrets in request: 2, the maximum is 1"},
+		{map[string]string{"FOO": strings.Repeat("a", 4097)}, "ciphertext of FOO is too large: 4097 bytes, the maximum is 4096"},
+	}
+	for _, test := range tests {
+		c := dial()
+		if err := decrypt(c, test.ciphertexts); err == nil || err.Message != test.want {
+			t.Errorf("want error %q, got %+v", test.want, err)
+		}
+		c.Close()
+	}
+
+	// a connection holds the only slot of the uid until it is closed
+	c := dial()
+	encoder, decoder := json.NewEncoder(c), json.NewDecoder(c)
+	testutil.MustPrefix(t, "could not send hello", encoder.Encode(&decryptionRequest{Hello: &hello{Version: protocolVersion}}))
+	var dresp decryptionResponse
+	testutil.MustPrefix(t, "could not read hello", decoder.Decode(&dresp))
+
+	other := dial()
+	want := fmt.Sprintf("too many connections from uid %d", os.Getuid())
+	if err := decrypt(other, map[string]string{"FOO": secret}); err == nil || err.Message != want {
+		t.Errorf("want error %q, got %+v", want, err)
+	}
+	other.Close()
+	client := newClientV2(&ConfigEntry{Envs: map[string]string{"FOO": secret}}, listener.Addr().String())
+	if err := client.Decrypt(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("want error %q, got %v", want, err)
+	}
+	c.Close()
+
+	// the slot is released once the connection is closed
+	for i := 0; ; i++ {
+		other := dial()
+		err := decrypt(other, map[string]string{"FOO": secret})
+		other.Close()
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("the uid connection slot was not released: %+v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerConnectionError(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, nil)
+	server.maxMessageSize = 1024
+	go server.ServeRPC(listener)
+
+	c, err := net.Dial("unix", listener.Addr().String())
+	testutil.MustPrefix(t, "could not connect to pald", err)
+	defer c.Close()
+	s := newSession(c)
+	_, err = s.roundTrip(&decryptionRequest{Hello: &hello{Version: protocolVersion}})
+	testutil.MustPrefix(t, "could not send hello", err)
+
+	// pald cannot tell which request a message too large is, and answers
+	// with an error about the connection
+	_, err = s.roundTrip(&decryptionRequest{ID: 1, Ciphertexts: map[string]string{"FOO": strings.Repeat("a", 2048)}})
+	if _, ok := err.(*decryptionError); !ok {
+		t.Errorf("want the error of pald, got %v", err)
+	}
+}
+
+// identityRetriever grants its identity to every process.
+type identityRetriever trustedlabels.Identity
+
+func (r *identityRetriever) IdentityForPID(int) (*trustedlabels.Identity, error) {
+	identity := trustedlabels.Identity(*r)
+	return &identity, nil
+}
+
+// memorySink keeps the audit records in memory.
+type memorySink struct {
+	mu      sync.Mutex
+	records []*audit.Record
+}
+
+func (m *memorySink) Log(r *audit.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, r)
+	return nil
+}
+
+func (m *memorySink) Close() error { return nil }
+
+func TestServerRateLimitsAndTripwire(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, &identityRetriever{
+		Labels:      map[string]struct{}{"app-foo": {}},
+		ContainerID: "c1",
+		ImageDigest: "sha256:abc",
+	})
+	sink := new(memorySink)
+	server.auditSink = sink
+	var err error
+	server.containerRate, err = newRateLimiter(&RateLimitConfig{Rate: 1, Burst: 2})
+	testutil.MustPrefix(t, "could not create rate limiter", err)
+	server.containerRate.now = func() time.Time { return time.Unix(0, 0) }
+	tripwirePath := filepath.Join(tempdir, "tripwire")
+	server.tripwire, err = newTripwire(tripwirePath)
+	testutil.MustPrefix(t, "could not create tripwire", err)
+	go server.ServeRPC(listener)
+
+	decrypt := func() error {
+		config := &ConfigEntry{Envs: map[string]string{"FOO": mustPGPEncrypt(t, "foo", "app-foo")}}
+		return newClientV2(config, listener.Addr().String()).Decrypt()
+	}
+	testutil.MustPrefix(t, "could not decrypt secrets", decrypt())
+	testutil.MustPrefix(t, "could not decrypt secrets", decrypt())
+	if err := decrypt(); err == nil || !strings.Contains(err.Error(), "rate limit exceeded for container c1") {
+		t.Errorf("want container rate limit error, got %v", err)
+	}
+
+	// secrets which are not returned are not charged
+	server.containerRate = nil
+	server.labelRate, err = newRateLimiter(&RateLimitConfig{Rate: 1})
+	testutil.MustPrefix(t, "could not create rate limiter", err)
+	server.labelRate.now = func() time.Time { return time.Unix(0, 0) }
+	config := &ConfigEntry{Envs: map[string]string{
+		"FOO": mustPGPEncrypt(t, "foo", "app-foo"),
+		"QUX": "pgp:" + base64.StdEncoding.EncodeToString([]byte("garbage")),
+	}}
+	if err := newClientV2(config, listener.Addr().String()).Decrypt(); err == nil || !strings.Contains(err.Error(), "Failed to decrypt secret") {
+		t.Errorf("want decryption error, got %v", err)
+	}
+	testutil.MustPrefix(t, "could not decrypt secrets after a failure", decrypt())
+	if err := decrypt(); err == nil || !strings.Contains(err.Error(), "rate limit exceeded for label app-foo") {
+		t.Errorf("want label rate limit error, got %v", err)
+	}
+
+	var got []string
+	for _, rec := range sink.records {
+		got = append(got, rec.Decision+":"+rec.Reason)
+	}
+	want := []string{
+		"alert:label app-foo requested for the first time by image sha256:abc",
+		"allow:",
+		"allow:",
+		"deny:rate limit exceeded for container c1",
+		"deny:request failed on secret QUX",
+		"error:Failed to decrypt secret: openpgp: invalid data: tag byte does not have MSB set",
+		"allow:",
+		"deny:rate limit exceeded for label app-foo",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want audit records %q, got %q", want, got)
+	}
+
+	// the labels seen survive a restart
+	tripwire, err := newTripwire(tripwirePath)
+	testutil.MustPrefix(t, "could not reload tripwire", err)
+	defer tripwire.Close()
+	if first, err := tripwire.observe("sha256:abc", "app-foo"); first || err != nil {
+		t.Errorf("want app-foo already seen, got %v, %v", first, err)
+	}
+	if first, err := tripwire.observe("sha256:def", "app-foo"); !first || err != nil {
+		t.Errorf("want app-foo seen for the first time by a new image, got %v, %v", first, err)
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	tempdir := testutil.MustTempDir(t, "", "pal-test")
+	defer os.RemoveAll(tempdir)
+	path := filepath.Join(tempdir, "config.yaml")
+	keyring, err := filepath.Abs("testdata/secring.gpg")
+	testutil.MustPrefix(t, "could not find keyring", err)
+	writeConfig := func(extra string) {
+		config := fmt.Sprintf("prod:\n  pgp_keyring_path: %s\n  pgp_passphrase: paltest\n%s", keyring, extra)
+		testutil.MustPrefix(t, "could not write config", ioutil.WriteFile(path, []byte(config), 0600))
+	}
+	counter := func(vec *prometheus.CounterVec, label string) float64 {
+		var m dto.Metric
+		testutil.MustPrefix(t, "could not read metric", vec.WithLabelValues(label).Write(&m))
+		return m.GetCounter().GetValue()
+	}
+
+	writeConfig("")
+	server, err := NewServer(&ServerConfigEntry{PGPKeyRingPath: keyring, PGPPassphrase: "paltest"})
+	testutil.MustPrefix(t, "could not create pald server", err)
+	old := server.state()
+
+	writeConfig("  backend_concurrency: {ro: 1}\n")
+	if err := server.Reload(path, "prod"); err == nil || !strings.Contains(err.Error(), `unknown backend "ro"`) {
+		t.Errorf("want unknown backend error, got %v", err)
+	}
+	if err := server.Reload(path, "dev"); err == nil {
+		t.Error("want an error for an unknown environment")
+	}
+	if server.state() != old || counter(server.reloads, "failure") != 2 {
+		t.Errorf("want the state kept after failed reloads, got %d failures", int(counter(server.reloads, "failure")))
+	}
+
+	writeConfig("  rate_limits: {image: {rate: 1}}\n")
+	if err := server.Reload(path, "prod"); err == nil || !strings.Contains(err.Error(), "labels_enabled") {
+		t.Errorf("want an error for an image rate limit without labels, got %v", err)
+	}
+
+	closer := &closingRetriever{}
+	old.labelsRetriever = closer
+	inflight := server.acquireState()
+	writeConfig("  require_sealing: true\n")
+	testutil.MustPrefix(t, "could not reload", server.Reload(path, "prod"))
+	st := server.state()
+	if st == old || !st.requireSealing || counter(server.reloads, "success") != 1 {
+		t.Errorf("want a new state requiring sealing, got %+v", st)
+	}
+	// the replaced state is closed once the requests using it complete
+	if closer.closed {
+		t.Error("want the replaced state kept open while a request uses it")
+	}
+	inflight.release()
+	if !closer.closed {
+		t.Error("want the replaced state closed")
+	}
+	// the requests served with both states share the limits of pgp
+	if st.backendLimits["pgp"] != old.backendLimits["pgp"] {
+		t.Error("want the pgp concurrency limit kept across reloads")
+	}
+}
+
+// closingRetriever records whether it was closed.
+type closingRetriever struct {
+	closed bool
+}
+
+func (*closingRetriever) IdentityForPID(int) (*trustedlabels.Identity, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *closingRetriever) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestHashConfig(t *testing.T) {
+	key := []byte("key")
+	config := &ServerConfigEntry{PGPKeyRingPath: "secring.gpg", PGPPassphrase: "paltest", Password: "hunter2"}
+	hash := hashConfig(config, key)
+	if other := hashConfig(&ServerConfigEntry{PGPKeyRingPath: "secring.gpg", PGPPassphrase: "paltest", Password: "hunter2"}, key); other != hash {
+		t.Errorf("want the same hash for the same configuration, got %s and %s", hash, other)
+	}
+	if config.PGPPassphrase != "paltest" || config.Password != "hunter2" {
+		t.Error("want the configuration unchanged")
+	}
+	for _, other := range []*ServerConfigEntry{
+		{PGPKeyRingPath: "other.gpg", PGPPassphrase: "paltest", Password: "hunter2"},
+		{PGPKeyRingPath: "secring.gpg", PGPPassphrase: "rotated", Password: "hunter2"},
+		{PGPKeyRingPath: "secring.gpg", PGPPassphrase: "paltest", Password: "rotated"},
+	} {
+		if hashConfig(other, key) == hash {
+			t.Errorf("want the hash to change with the configuration %+v", other)
+		}
+	}
+	// the passwords cannot be checked without the key
+	if hashConfig(config, []byte("other")) == hash {
+		t.Error("want the hash to depend on the key")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+
+	slow := &slowDecrypter{delay: 200 * time.Millisecond}
+	server := mustPGPServer(t, nil)
+	server.state().decrypters["slow"] = slow
+	served := make(chan error, 1)
+	go func() { served <- server.ServeRPC(listener) }()
+
+	c, err := net.Dial("unix", listener.Addr().String())
+	testutil.MustPrefix(t, "could not connect to pald", err)
+	defer c.Close()
+	encoder, decoder := json.NewEncoder(c), json.NewDecoder(c)
+	testutil.MustPrefix(t, "could not send hello", encoder.Encode(&decryptionRequest{Hello: &hello{Version: protocolVersion}}))
+	var dresp decryptionResponse
+	testutil.MustPrefix(t, "could not read hello", decoder.Decode(&dresp))
+	testutil.MustPrefix(t, "healthy server", server.Healthy(time.Second))
+
+	dreq := &decryptionRequest{ID: 1, Ciphertexts: map[string]string{"FOO": "slow:" + base64.StdEncoding.EncodeToString([]byte("foo"))}}
+	testutil.MustPrefix(t, "could not send request", encoder.Encode(dreq))
+	// let the request be read before draining
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	testutil.MustPrefix(t, "could not drain server", server.Shutdown(ctx))
+
+	// the request in flight was answered before the connection was closed
+	dresp = decryptionResponse{}
+	testutil.MustPrefix(t, "could not read response", decoder.Decode(&dresp))
+	if dresp.ID != 1 || dresp.Secrets["FOO"] != "foo" {
+		t.Errorf("want FOO decrypted, got %+v", dresp)
+	}
+	if err := decoder.Decode(&dresp); err != io.EOF {
+		t.Errorf("want the connection closed, got %v", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("want ErrServerClosed, got %v", err)
+	}
+	if _, err := net.Dial("unix", listener.Addr().String()); err == nil {
+		t.Error("want the listener closed")
+	}
+}
+
+// brokenRetriever fails its readiness check.
+type brokenRetriever struct{ trustedlabels.Retriever }
+
+func (brokenRetriever) Check() error { return errors.New("dockerd is unreachable") }
+
+func TestServerHealth(t *testing.T) {
+	server := mustPGPServer(t, mockLabelsRetriever)
+	server.state().decrypters["slow"] = &slowDecrypter{}
+	get := func(handler http.HandlerFunc) (int, *healthReport) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/", nil))
+		report := new(healthReport)
+		testutil.MustPrefix(t, "could not parse health report", json.Unmarshal(w.Body.Bytes(), report))
+		return w.Code, report
+	}
+	status := func(report *healthReport) map[string]string {
+		got := make(map[string]string)
+		for name, c := range report.Components {
+			got[name] = c.Status + ":" + c.Error
+		}
+		return got
+	}
+
+	if code, report := get(server.Healthz); code != http.StatusOK || report.Status != healthOK {
+		t.Errorf("want a healthy server, got %d %+v", code, report)
+	}
+
+	code, report := get(server.Readyz)
+	want := map[string]string{
+		"decrypter/pgp":    "ok:",
+		"decrypter/slow":   "unchecked:",
+		"labels_retriever": "unchecked:",
+		"server":           "ok:",
+	}
+	if code != http.StatusOK || !reflect.DeepEqual(status(report), want) {
+		t.Errorf("want ready components %v, got %d %v", want, code, status(report))
+	}
+
+	server.state().labelsRetriever = brokenRetriever{mockLabelsRetriever}
+	testutil.MustPrefix(t, "could not drain server", server.Shutdown(context.Background()))
+	code, report = get(server.Readyz)
+	want["labels_retriever"] = "fail:dockerd is unreachable"
+	want["server"] = "fail:draining"
+	if code != http.StatusServiceUnavailable || report.Status != healthFail || !reflect.DeepEqual(status(report), want) {
+		t.Errorf("want unready components %v, got %d %v", want, code, status(report))
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, &identityRetriever{
+		Labels:    map[string]struct{}{"app-foo": {}},
+		ImageName: "foo:latest",
+	})
+	go server.ServeRPC(listener)
+
+	config := &ConfigEntry{Envs: map[string]string{"FOO": mustPGPEncrypt(t, "foo", "app-foo")}}
+	testutil.MustPrefix(t, "could not decrypt secrets", newClientV2(config, listener.Addr().String()).Decrypt())
+	config.Envs = map[string]string{"SECRET": mustPGPEncrypt(t, "secret", "db-prod")}
+	if err := newClientV2(config, listener.Addr().String()).Decrypt(); err == nil || !strings.Contains(err.Error(), "code: 103") {
+		t.Errorf("want unauthorized error, got %v", err)
+	}
+
+	var m dto.Metric
+	testutil.MustPrefix(t, "could not read metric", server.denials.WithLabelValues("db-prod", "foo:latest").Write(&m))
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("want 1 denial of db-prod to foo:latest, got %v", got)
+	}
+	for label, want := range map[string]float64{"app-foo": 1, "db-prod": 0} {
+		testutil.MustPrefix(t, "could not read metric", server.counter.WithLabelValues(label).Write(&m))
+		if got := m.GetCounter().GetValue(); got != want {
+			t.Errorf("want %v decryptions of %s, got %v", want, label, got)
+		}
+	}
+	testutil.MustPrefix(t, "could not read metric", server.errors.WithLabelValues("103", "pgp").Write(&m))
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("want 1 unauthorized error for pgp, got %v", got)
+	}
+	// the client sends a request for its files too, even without any
+	tests := []struct {
+		name      string
+		histogram prometheus.Histogram
+		want      uint64
+	}{
+		{"request", server.requestDuration, 3},
+		{"identity", server.identityDuration, 3},
+		{"pgp", server.backendDuration.WithLabelValues("pgp").(prometheus.Histogram), 2},
+	}
+	for _, test := range tests {
+		testutil.MustPrefix(t, "could not read metric", test.histogram.Write(&m))
+		if got := m.GetHistogram().GetSampleCount(); got != test.want {
+			t.Errorf("want %d %s durations, got %d", test.want, test.name, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package pal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/cloudflare/pal/log"
+)
+
+// ErrServerClosed is returned by ServeRPC after a call to Shutdown.
+var ErrServerClosed = errors.New("pal: server closed")
+
+// Shutdown drains s: it closes the RPC listeners, stops reading requests from
+// the connections being served and waits for the requests already read to be
+// answered, or for ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connMu.Lock()
+	select {
+	case <-s.draining:
+	default:
+		close(s.draining)
+	}
+	for l := range s.listeners {
+		if err := l.Close(); err != nil {
+			log.Errorf("Failed to close %s: %v", l.Addr(), err)
+		}
+	}
+	// wake up the connections waiting for a request
+	for c := range s.conns {
+		c.SetReadDeadline(time.Now())
+	}
+	s.connMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to drain connections: %v", ctx.Err())
+	}
+}
+
+// isDraining reports whether Shutdown was called.
+func (s *Server) isDraining() bool {
+	select {
+	case <-s.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackListener registers l to be closed by Shutdown, which then waits for
+// untrackListener. It returns false if s is already draining.
+func (s *Server) trackListener(l net.Listener, pool *workerPool) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.isDraining() {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	s.pools[pool] = struct{}{}
+	s.connWG.Add(1)
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener, pool *workerPool) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	delete(s.listeners, l)
+	delete(s.pools, pool)
+	s.connWG.Done()
+}
+
+// trackConn registers c to be drained by Shutdown. It returns false if s is
+// already draining.
+func (s *Server) trackConn(c net.Conn) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.isDraining() {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.connWG.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(c net.Conn) {
+	s.connMu.Lock()
+	delete(s.conns, c)
+	s.connMu.Unlock()
+	s.connWG.Done()
+}
+
+// waitRequest sets the deadline of c to read the next request. It returns false
+// if s is draining, in which case no further request should be read.
+func (s *Server) waitRequest(c net.Conn) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.isDraining() {
+		return false
+	}
+	c.SetReadDeadline(time.Now().Add(s.readTimeout))
+	return true
+}
+
+// Healthy checks that none of the worker pools of the RPC listeners of s is
+// stalled, i.e. that no request has been waiting for a worker for window
+// while no request completed. Pools whose workers are all busy but still
+// complete requests are healthy. It is meant to gate the pings of the systemd
+// watchdog.
+func (s *Server) Healthy(window time.Duration) error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	for pool := range s.pools {
+		if pool.stalled(window) {
+			return fmt.Errorf("no RPC request completed for %v while requests wait for a worker", window)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package pal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// ListenTLS listens for mutually authenticated TLS connections on the TCP
+// address addr, for callers without access to the pald unix socket such as
+// workloads on VMs. The server certificate is TLSCert and TLSKey, and clients
+// must present a certificate signed by TLSClientCA. Their labels are granted
+// by CertificateLabels.
+func ListenTLS(addr string, config *ServerConfigEntry) (net.Listener, error) {
+	if config.TLSCert == "" || config.TLSKey == "" || config.TLSClientCA == "" {
+		return nil, fmt.Errorf("tls_cert, tls_key and tls_client_ca are required to listen on %s", addr)
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCertPool(config.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	})
+}
+
+// NewClientTLSConfig returns the TLS configuration of a client connecting to
+// pald over TCP with the given certificate, trusting the server certificates
+// signed by the CA in caFile.
+func NewClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,62 @@
+package pal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// tripwire remembers the labels requested by each image digest, to raise an
+// alert the first time an image requests a label, e.g. a freshly built image
+// suddenly reaching for production database credentials. The pairs seen are
+// appended to a file, one "digest label" pair per line, so that they are not
+// reported again after a restart.
+type tripwire struct {
+	mu   sync.Mutex
+	seen map[string]bool
+	file *os.File
+}
+
+// newTripwire loads the pairs seen so far from the file at path, creating it if
+// it does not exist.
+func newTripwire(path string) (*tripwire, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tripwire file: %v", err)
+	}
+	t := &tripwire{seen: make(map[string]bool), file: f}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			t.seen[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read tripwire file %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// observe records that digest requested label and reports whether it did for
+// the first time.
+func (t *tripwire) observe(digest, label string) (bool, error) {
+	key := digest + " " + label
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen[key] {
+		return false, nil
+	}
+	t.seen[key] = true
+	if _, err := fmt.Fprintln(t.file, key); err != nil {
+		return true, fmt.Errorf("failed to write tripwire file: %v", err)
+	}
+	return true, nil
+}
+
+// Close closes the file of t.
+func (t *tripwire) Close() error {
+	return t.file.Close()
+}
@@ -0,0 +1,89 @@
+package trustedlabels
+
+import (
+	"crypto/x509"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// A CertificateRetriever identifies a remote caller, such as a workload on a
+// VM, by the TLS client certificate it authenticated with.
+type CertificateRetriever interface {
+	IdentityForCertificate(cert *x509.Certificate) (*Identity, error)
+}
+
+type certificate struct {
+	labels map[string][]string
+}
+
+// NewCertificate returns a CertificateRetriever which grants labels to
+// certificate identities. The identity of a certificate is its SPIFFE ID, the
+// spiffe:// URI in its SAN, or if it has none, each of its DNS names prefixed
+// with "dns:". A key of labels ending with "*" matches every identity starting
+// with what precedes the "*", such as "spiffe://example.org/ns/prod/*".
+func NewCertificate(labels map[string][]string) CertificateRetriever {
+	return &certificate{labels: labels}
+}
+
+func (c *certificate) IdentityForCertificate(cert *x509.Certificate) (*Identity, error) {
+	ids, err := certificateIDs(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Labels:        make(map[string]struct{}),
+		CertificateID: ids[0],
+	}
+	// iterate in a fixed order so that the labels do not depend on map order
+	keys := make([]string, 0, len(c.labels))
+	for key := range c.labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, id := range ids {
+		for _, key := range keys {
+			if !matchCertificateID(key, id) {
+				continue
+			}
+			for _, label := range c.labels[key] {
+				identity.Labels[label] = struct{}{}
+			}
+		}
+	}
+	return identity, nil
+}
+
+// certificateIDs returns the identities of cert.
+func certificateIDs(cert *x509.Certificate) ([]string, error) {
+	var spiffe []string
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			spiffe = append(spiffe, uri.String())
+		}
+	}
+	switch {
+	case len(spiffe) == 1:
+		return spiffe, nil
+	case len(spiffe) > 1:
+		// an SVID carries exactly one SPIFFE ID
+		return nil, errors.New("certificate has several SPIFFE IDs")
+	}
+
+	var ids []string
+	for _, name := range cert.DNSNames {
+		ids = append(ids, "dns:"+name)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("certificate has neither a SPIFFE ID nor a DNS name")
+	}
+	return ids, nil
+}
+
+func matchCertificateID(pattern, id string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(id, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == id
+}
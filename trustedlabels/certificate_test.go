@@ -0,0 +1,81 @@
+package trustedlabels
+
+import (
+	"crypto/x509"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCertificateRetriever(t *testing.T) {
+	r := NewCertificate(map[string][]string{
+		"spiffe://example.org/vm/db/*": {"db-prod"},
+		"spiffe://example.org/vm/web":  {"web"},
+		"dns:batch.example.org":        {"batch"},
+	})
+	spiffe := func(ids ...string) []*url.URL {
+		var uris []*url.URL
+		for _, id := range ids {
+			u, err := url.Parse(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uris = append(uris, u)
+		}
+		return uris
+	}
+
+	tests := []struct {
+		Cert   *x509.Certificate
+		ID     string
+		Labels []string
+		Err    bool
+	}{
+		{
+			Cert:   &x509.Certificate{URIs: spiffe("spiffe://example.org/vm/db/1")},
+			ID:     "spiffe://example.org/vm/db/1",
+			Labels: []string{"db-prod"},
+		},
+		{
+			// the SPIFFE ID takes precedence over DNS names
+			Cert: &x509.Certificate{
+				URIs:     spiffe("spiffe://example.org/vm/web/1"),
+				DNSNames: []string{"batch.example.org"},
+			},
+			ID: "spiffe://example.org/vm/web/1",
+		},
+		{
+			Cert:   &x509.Certificate{DNSNames: []string{"other.example.org", "batch.example.org"}},
+			ID:     "dns:other.example.org",
+			Labels: []string{"batch"},
+		},
+		{
+			Cert: &x509.Certificate{URIs: spiffe("spiffe://example.org/a", "spiffe://example.org/b")},
+			Err:  true,
+		},
+		{
+			Cert: &x509.Certificate{},
+			Err:  true,
+		},
+	}
+	for i, test := range tests {
+		identity, err := r.IdentityForCertificate(test.Cert)
+		if test.Err {
+			if err == nil {
+				t.Errorf("%d: want error, got %+v", i, identity)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		want := make(map[string]struct{})
+		for _, label := range test.Labels {
+			want[label] = struct{}{}
+		}
+		if identity.CertificateID != test.ID || !reflect.DeepEqual(identity.Labels, want) {
+			t.Errorf("%d: want %s with labels %v, got %s with %v", i, test.ID, test.Labels, identity.CertificateID, identity.Labels)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package trustedlabels
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A Trace records the decisions of the sources of a composite Retriever.
+type Trace struct {
+	// Sources lists the names of the sources that were consulted, in order.
+	Sources []string
+	// Granted maps each label granted by at least one source to the names of
+	// the sources that granted it.
+	Granted map[string][]string
+	// Errors maps the names of the sources that failed to their error.
+	Errors map[string]error
+}
+
+func newTrace() *Trace {
+	return &Trace{
+		Granted: make(map[string][]string),
+		Errors:  make(map[string]error),
+	}
+}
+
+// Explain describes how each source decided on label, for instance
+// "granted by docker; denied by k8s".
+func (t *Trace) Explain(label string) string {
+	var granted, denied, failed []string
+	for _, source := range t.Sources {
+		switch {
+		case t.Errors[source] != nil:
+			failed = append(failed, fmt.Sprintf("%s (%v)", source, t.Errors[source]))
+		case containsString(t.Granted[label], source):
+			granted = append(granted, source)
+		default:
+			denied = append(denied, source)
+		}
+	}
+	var parts []string
+	if len(granted) > 0 {
+		parts = append(parts, "granted by "+strings.Join(granted, ", "))
+	}
+	if len(denied) > 0 {
+		parts = append(parts, "denied by "+strings.Join(denied, ", "))
+	}
+	if len(failed) > 0 {
+		parts = append(parts, "failed in "+strings.Join(failed, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Labels returns the sorted list of labels that any source granted.
+func (t *Trace) Labels() []string {
+	labels := make([]string, 0, len(t.Granted))
+	for label := range t.Granted {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// A Source is a named Retriever used by a composite Retriever. The name
+// identifies the source in a Trace, so the sources of a composite must have
+// distinct names.
+type Source struct {
+	Name      string
+	Retriever Retriever
+}
+
+type composite struct {
+	sources   []Source
+	intersect bool
+}
+
+// NewUnion returns a Retriever that grants every label granted by any of the
+// sources. Sources that fail are ignored unless all of them fail.
+func NewUnion(sources ...Source) Retriever {
+	return &composite{sources: sources}
+}
+
+// NewIntersection returns a Retriever that only grants the labels granted by
+// all of the sources. It fails if any of the sources fails.
+func NewIntersection(sources ...Source) Retriever {
+	return &composite{sources: sources, intersect: true}
+}
+
+// IdentityForPID returns the labels granted by the combination of the sources
+// together with a Trace of their decisions. The other attributes are merged
+// from the sources; the first source to set an attribute wins.
+func (c *composite) IdentityForPID(pid int) (*Identity, error) {
+	if len(c.sources) == 0 {
+		return nil, errors.New("composite labels retriever without sources")
+	}
+	trace := newTrace()
+	merged := &Identity{
+		Labels:     make(map[string]struct{}),
+		Attributes: make(map[string]string),
+		Trace:      trace,
+	}
+	for _, source := range c.sources {
+		trace.Sources = append(trace.Sources, source.Name)
+		identity, err := source.Retriever.IdentityForPID(pid)
+		if err != nil {
+			trace.Errors[source.Name] = err
+			if c.intersect {
+				return nil, fmt.Errorf("%s: %v", source.Name, err)
+			}
+			continue
+		}
+		for label := range identity.Labels {
+			trace.Granted[label] = append(trace.Granted[label], source.Name)
+		}
+		merged.merge(identity)
+	}
+	if len(trace.Errors) == len(c.sources) {
+		return nil, fmt.Errorf("all labels retrievers failed: %s", trace.Explain(""))
+	}
+
+	for label, sources := range trace.Granted {
+		if !c.intersect || len(sources) == len(c.sources) {
+			merged.Labels[label] = struct{}{}
+		}
+	}
+	return merged, nil
+}
+
+// Check checks the sources that support it, e.g. that the Docker daemon can be
+// reached. A union fails only if all of its sources fail.
+func (c *composite) Check() error {
+	var errs []string
+	for _, source := range c.sources {
+		checker, ok := source.Retriever.(interface {
+			Check() error
+		})
+		if !ok {
+			continue
+		}
+		if err := checker.Check(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", source.Name, err))
+		}
+	}
+	if len(errs) == 0 || (!c.intersect && len(errs) < len(c.sources)) {
+		return nil
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// CacheStats sums the statistics of the caches of the sources.
+func (c *composite) CacheStats() CacheStats {
+	var stats CacheStats
+	for _, source := range c.sources {
+		if cr, ok := source.Retriever.(CachingRetriever); ok {
+			s := cr.CacheStats()
+			stats.Hits += s.Hits
+			stats.Misses += s.Misses
+			stats.Entries += s.Entries
+		}
+	}
+	return stats
+}
+
+// Flush empties the caches of the sources.
+func (c *composite) Flush() error {
+	var errs []string
+	for _, source := range c.sources {
+		if cr, ok := source.Retriever.(CachingRetriever); ok {
+			if err := cr.Flush(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", source.Name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close closes the sources that need it.
+func (c *composite) Close() error {
+	var errs []string
+	for _, source := range c.sources {
+		if closer, ok := source.Retriever.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", source.Name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// merge sets the attributes of i that are still empty from other. Labels and
+// traces are not merged.
+func (i *Identity) merge(other *Identity) {
+	setIfEmpty := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	setIfEmpty(&i.ContainerID, other.ContainerID)
+	setIfEmpty(&i.ImageName, other.ImageName)
+	setIfEmpty(&i.ImageDigest, other.ImageDigest)
+	setIfEmpty(&i.SignerRole, other.SignerRole)
+	setIfEmpty(&i.Pod, other.Pod)
+	setIfEmpty(&i.PodNamespace, other.PodNamespace)
+	setIfEmpty(&i.PodUID, other.PodUID)
+	setIfEmpty(&i.CertificateID, other.CertificateID)
+	for k, v := range other.Attributes {
+		if _, ok := i.Attributes[k]; !ok {
+			i.Attributes[k] = v
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
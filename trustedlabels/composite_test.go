@@ -0,0 +1,105 @@
+package trustedlabels
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type failing struct{}
+
+func (failing) IdentityForPID(int) (*Identity, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (failing) Check() error {
+	return errors.New("unreachable")
+}
+
+func TestComposite(t *testing.T) {
+	docker := Source{Name: "docker", Retriever: NewMock(map[string]struct{}{"a": {}, "b": {}})}
+	k8s := Source{Name: "k8s", Retriever: NewMock(map[string]struct{}{"b": {}, "c": {}})}
+	broken := Source{Name: "broken", Retriever: failing{}}
+
+	tests := []struct {
+		retriever Retriever
+		labels    map[string]struct{}
+		explain   map[string]string
+		err       string
+	}{
+		{
+			retriever: NewUnion(docker, k8s),
+			labels:    map[string]struct{}{"a": {}, "b": {}, "c": {}},
+			explain: map[string]string{
+				"a": "granted by docker; denied by k8s",
+				"b": "granted by docker, k8s",
+				"d": "denied by docker, k8s",
+			},
+		},
+		{
+			retriever: NewIntersection(docker, k8s),
+			labels:    map[string]struct{}{"b": {}},
+			explain: map[string]string{
+				"c": "granted by k8s; denied by docker",
+			},
+		},
+		{
+			retriever: NewUnion(broken, k8s),
+			labels:    map[string]struct{}{"b": {}, "c": {}},
+			explain: map[string]string{
+				"b": "granted by k8s; failed in broken (unreachable)",
+			},
+		},
+		{
+			retriever: NewIntersection(docker, broken),
+			err:       "broken: unreachable",
+		},
+		{
+			retriever: NewUnion(broken),
+			err:       "all labels retrievers failed: failed in broken (unreachable)",
+		},
+	}
+
+	for i, test := range tests {
+		identity, err := test.retriever.IdentityForPID(1)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("test %d: want error %q, got %v", i, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(identity.Labels, test.labels) {
+			t.Errorf("test %d: want labels %v, got %v", i, test.labels, identity.Labels)
+		}
+		for label, want := range test.explain {
+			if got := identity.Trace.Explain(label); got != want {
+				t.Errorf("test %d: want explanation %q for %s, got %q", i, want, label, got)
+			}
+		}
+	}
+}
+
+func TestCompositeCheck(t *testing.T) {
+	mock := Source{Name: "mock", Retriever: NewMock(nil)}
+	broken := Source{Name: "broken", Retriever: failing{}}
+
+	tests := []struct {
+		retriever Retriever
+		err       string
+	}{
+		{NewUnion(mock, broken), ""},
+		{NewUnion(broken, broken), "broken: unreachable; broken: unreachable"},
+		{NewIntersection(mock, broken), "broken: unreachable"},
+		{NewIntersection(mock), ""},
+	}
+	for i, test := range tests {
+		err := test.retriever.(*composite).Check()
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("%d: want error %q, got %v", i, test.err, err)
+		}
+	}
+}
@@ -1,23 +1,113 @@
 package trustedlabels
 
-// A Retriever is a type capable of looking up the docker image labels for a
-// given PID. In other words, if a docker image, I, is used to launch a
-// container, C, and that container contains a process with PID P, then calling
-// LabelsForPID(P) will return all of the labels associated with I.
+import "strings"
+
+// A Retriever is a type capable of identifying the caller with a given PID,
+// including the docker image labels granted to it. In other words, if a docker
+// image, I, is used to launch a container, C, and that container contains a
+// process with PID P, then calling IdentityForPID(P) will return an Identity
+// describing C and I that carries all of the labels associated with I.
 type Retriever interface {
-	LabelsForPID(pid int) (map[string]struct{}, error)
+	IdentityForPID(pid int) (*Identity, error)
+}
+
+// CacheStats describes the cache of a Retriever.
+type CacheStats struct {
+	// Hits and Misses count the lookups answered from the cache or not.
+	Hits, Misses uint64
+	// Entries is the number of entries in the cache.
+	Entries int
+}
+
+// A CachingRetriever is a Retriever which caches part of its lookups, such as
+// the trust verifications of the Docker retriever.
+type CachingRetriever interface {
+	Retriever
+	CacheStats() CacheStats
+	// Flush empties the cache, e.g. after an image was signed again.
+	Flush() error
+}
+
+// An Identity is everything a Retriever learned about a caller. Fields that do
+// not apply to a particular Retriever are left empty.
+type Identity struct {
+	// Labels is the set of labels granted to the caller.
+	Labels map[string]struct{}
+
+	ContainerID string
+	// ImageName is the name of the image of the container, such as
+	// "docker.io/library/foo:latest".
+	ImageName string
+	// ImageDigest is the content digest of the image, such as "sha256:...".
+	ImageDigest string
+	// SignerRole is the TUF role that signed ImageDigest.
+	SignerRole string
+
+	Pod          string
+	PodNamespace string
+	PodUID       string
+
+	// CertificateID is the SPIFFE ID or DNS name of the client certificate of
+	// a remote caller.
+	CertificateID string
+
+	// Attributes holds any additional information a Retriever wishes to
+	// expose about the caller.
+	Attributes map[string]string
+
+	// Trace explains which sources of a composite Retriever granted or denied
+	// each label. It is nil for other retrievers.
+	Trace *Trace
+}
+
+// HasLabel reports whether label is granted to the caller.
+func (i *Identity) HasLabel(label string) bool {
+	_, ok := i.Labels[label]
+	return ok
+}
+
+// String describes the caller for use in logs and error messages.
+func (i *Identity) String() string {
+	var parts []string
+	if i.ContainerID != "" {
+		id := i.ContainerID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		parts = append(parts, "container "+id)
+	}
+	if i.ImageName != "" {
+		image := "image " + i.ImageName
+		if i.ImageDigest != "" {
+			image += "@" + i.ImageDigest
+		}
+		if i.SignerRole != "" {
+			image += " signed by " + i.SignerRole
+		}
+		parts = append(parts, image)
+	}
+	if i.Pod != "" {
+		parts = append(parts, "pod "+i.PodNamespace+"/"+i.Pod)
+	}
+	if i.CertificateID != "" {
+		parts = append(parts, "certificate "+i.CertificateID)
+	}
+	if len(parts) == 0 {
+		return "unknown caller"
+	}
+	return strings.Join(parts, ", ")
 }
 
 type mock struct {
 	labels map[string]struct{}
 }
 
-// NewMock returns a mocked Retriever which always responds with the given set
-// of labels to any request.
+// NewMock returns a mocked Retriever which always responds with an Identity
+// carrying the given set of labels to any request.
 func NewMock(labels map[string]struct{}) Retriever {
 	return &mock{labels: labels}
 }
 
-func (m *mock) LabelsForPID(int) (map[string]struct{}, error) {
-	return m.labels, nil
+func (m *mock) IdentityForPID(int) (*Identity, error) {
+	return &Identity{Labels: m.labels}, nil
 }
@@ -9,9 +9,12 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudflare/pal/log"
@@ -37,6 +40,11 @@ var (
 	trustedReleaseRole = path.Join(string(data.CanonicalTargetsRole), "releases")
 	palLabel           = "pal.labels"
 
+	// labels set by the kubelet on the containers of a pod
+	k8sPodNameLabel      = "io.kubernetes.pod.name"
+	k8sPodNamespaceLabel = "io.kubernetes.pod.namespace"
+	k8sPodUIDLabel       = "io.kubernetes.pod.uid"
+
 	// ErrUnknownContainer is the error used when a Docker container could not be
 	// identified.
 	ErrUnknownContainer = errors.New("unknown docker container")
@@ -45,13 +53,24 @@ var (
 type docker struct {
 	trustServer  string
 	trustBaseDir string
+	trustCache   *trustCache
 	dockerClient *client.Client
+
+	// guns are the repositories whose trust was looked up since startup,
+	// the only ones whose TUF metadata matters to Check.
+	mu   sync.Mutex
+	guns map[string]struct{}
 }
 
 // NewDocker returns a new Retriever that uses the provided notary server and
 // trust store base directory to look up labels in the Docker daemon and then
 // validate the associated images' cryptographic signatures.
-func NewDocker(trustServer string, trustBaseDir string) (Retriever, error) {
+//
+// If cacheTTL is positive, the outcome of each signature verification is
+// persisted in the trust store base directory and reused for up to cacheTTL,
+// or until the underlying TUF metadata expires if that is sooner. This allows
+// previously verified images to be verified while the notary server is down.
+func NewDocker(trustServer string, trustBaseDir string, cacheTTL time.Duration) (Retriever, error) {
 	if trustServer == "" {
 		trustServer = registry.NotaryServer
 	}
@@ -62,14 +81,83 @@ func NewDocker(trustServer string, trustBaseDir string) (Retriever, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &docker{
+	d := &docker{
 		trustServer:  trustServer,
 		trustBaseDir: trustBaseDir,
 		dockerClient: c,
-	}, nil
+		guns:         make(map[string]struct{}),
+	}
+	if cacheTTL > 0 {
+		d.trustCache = newTrustCache(filepath.Join(trustBaseDir, trustCacheFile), cacheTTL)
+	}
+	return d, nil
+}
+
+// CacheStats describes the trust cache, which is empty if it is disabled.
+func (d *docker) CacheStats() CacheStats {
+	if d.trustCache == nil {
+		return CacheStats{}
+	}
+	return d.trustCache.stats()
+}
+
+// Flush empties the trust cache, so that every image is verified again.
+func (d *docker) Flush() error {
+	if d.trustCache == nil {
+		return nil
+	}
+	return d.trustCache.flush()
+}
+
+// Close closes the connections to the Docker daemon.
+func (d *docker) Close() error {
+	return d.dockerClient.Close()
+}
+
+// checkTimeout bounds the time taken by each request of Check.
+const checkTimeout = 5 * time.Second
+
+// Check reports whether the Docker daemon and the notary trust server are
+// reachable. Any answer of the trust server that is not a server error counts,
+// since it may require authentication. It also fails when the TUF metadata of
+// a repository looked up since startup has expired, or when the trust cache
+// has not been refreshed within its TTL, as images can then no longer be
+// verified offline.
+func (d *docker) Check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	if _, err := d.dockerClient.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to reach the Docker daemon: %v", err)
+	}
+
+	c := &http.Client{Timeout: checkTimeout}
+	resp, err := c.Get(d.trustServer + "/v2/")
+	if err != nil {
+		return fmt.Errorf("failed to reach trust server %s: %v", d.trustServer, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("trust server %s answered %s", d.trustServer, resp.Status)
+	}
+
+	d.mu.Lock()
+	guns := make([]string, 0, len(d.guns))
+	for gun := range d.guns {
+		guns = append(guns, gun)
+	}
+	d.mu.Unlock()
+	sort.Strings(guns)
+	if err := checkTUFExpiry(d.trustBaseDir, time.Now(), guns, data.CanonicalRootRole, data.CanonicalTimestampRole,
+		data.CanonicalSnapshotRole, data.CanonicalTargetsRole, trustedReleaseRole); err != nil {
+		return err
+	}
+	if d.trustCache != nil {
+		return d.trustCache.check()
+	}
+	return nil
 }
 
-func (d *docker) LabelsForPID(pid int) (map[string]struct{}, error) {
+func (d *docker) IdentityForPID(pid int) (*Identity, error) {
 	cgs, err := cgroups.ParseCgroupFile("/proc/" + strconv.Itoa(pid) + "/cgroup")
 	if err != nil {
 		return nil, err
@@ -100,7 +188,26 @@ func (d *docker) LabelsForPID(pid int) (map[string]struct{}, error) {
 		return nil, errors.New("image without tag or digests")
 	}
 
-	trusted, err := d.isTrusted(image.RepoTags[0], image.RepoDigests[0])
+	var containerLabels map[string]string
+	if container.Config != nil {
+		containerLabels = container.Config.Labels
+	}
+	// local repoDigest format of name@digest
+	localDigest := image.RepoDigests[0]
+	if arr := strings.SplitN(localDigest, "@", 2); len(arr) == 2 {
+		localDigest = arr[1]
+	}
+	identity := &Identity{
+		Labels:       make(map[string]struct{}),
+		ContainerID:  containerID,
+		ImageName:    image.RepoTags[0],
+		ImageDigest:  localDigest,
+		Pod:          containerLabels[k8sPodNameLabel],
+		PodNamespace: containerLabels[k8sPodNamespaceLabel],
+		PodUID:       containerLabels[k8sPodUIDLabel],
+	}
+
+	trusted, err := d.isTrusted(identity)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get trust status: %v", err)
 	}
@@ -108,32 +215,51 @@ func (d *docker) LabelsForPID(pid int) (map[string]struct{}, error) {
 		return nil, fmt.Errorf("image %s with digest %v is not trusted", image.RepoTags[0], image.RepoDigests)
 	}
 
-	labels := make(map[string]struct{})
 	if v, ok := image.Config.Labels[palLabel]; ok {
 		for _, label := range strings.Split(v, ",") {
-			labels[strings.TrimSpace(label)] = struct{}{}
+			identity.Labels[strings.TrimSpace(label)] = struct{}{}
 		}
 	}
-	return labels, nil
+	return identity, nil
 }
 
-func (d *docker) isTrusted(imageName string, localDigest string) (bool, error) {
-	ref, err := d.trustedReference(imageName)
+// isTrusted reports whether the image digest of identity is the one signed for
+// its image name, and records the signing role in identity.
+func (d *docker) isTrusted(identity *Identity) (bool, error) {
+	if ref, err := reference.ParseNamed(identity.ImageName); err == nil {
+		if repoInfo, err := registry.ParseRepositoryInfo(ref); err == nil {
+			d.mu.Lock()
+			d.guns[repoInfo.Name.String()] = struct{}{}
+			d.mu.Unlock()
+		}
+	}
+	if d.trustCache != nil {
+		if entry, ok := d.trustCache.get(identity.ImageName, identity.ImageDigest); ok {
+			identity.SignerRole = entry.Role
+			return entry.Trusted, nil
+		}
+	}
+
+	ref, role, expires, err := d.trustedReference(identity.ImageName)
 	if err != nil {
 		return false, err
 	}
 	remoteDigest := ref.Digest().String()
-	// local repoDigest format of name@digest
-	if arr := strings.SplitN(localDigest, "@", 2); len(arr) == 2 {
-		return remoteDigest == arr[1], nil
+	trusted := remoteDigest == identity.ImageDigest
+	if d.trustCache != nil {
+		d.trustCache.put(identity.ImageName, identity.ImageDigest, trusted, remoteDigest, role, expires)
 	}
-	return remoteDigest == localDigest, nil
+	identity.SignerRole = role
+	return trusted, nil
 }
 
-func (d *docker) trustedReference(name string) (reference.Canonical, error) {
+// trustedReference resolves name to the digest signed in the notary server and
+// the role that signed it. It also returns the earliest expiry of the TUF
+// metadata that the result was based on, or the zero time if it is unknown.
+func (d *docker) trustedReference(name string) (reference.Canonical, string, time.Time, error) {
 	ref, err := reference.ParseNamed(name)
 	if err != nil {
-		return nil, err
+		return nil, "", time.Time{}, err
 	}
 	namedTagged, ok := ref.(reference.NamedTagged)
 	if !ok {
@@ -147,30 +273,38 @@ func (d *docker) trustedReference(name string) (reference.Canonical, error) {
 
 	repoInfo, err := registry.ParseRepositoryInfo(ref)
 	if err != nil {
-		return nil, err
+		return nil, "", time.Time{}, err
 	}
 	notaryRepo, err := d.notaryRepository(repoInfo, types.AuthConfig{}, "pull")
 	if err != nil {
-		return nil, err
+		return nil, "", time.Time{}, err
 	}
 
 	t, err := notaryRepo.GetTargetByName(namedTagged.Tag(), trustedReleaseRole, data.CanonicalTargetsRole)
 	if err != nil {
-		return nil, err
+		return nil, "", time.Time{}, err
 	}
 
 	// Only list tags in the top level targets role or the releases delegation role
 	// ignore all other delegation roles
 	if t.Role != trustedReleaseRole && t.Role != data.CanonicalTargetsRole {
-		return nil, fmt.Errorf("failed %v: %v", repoInfo.Name, fmt.Errorf("No trust data for %s", namedTagged.Tag()))
+		return nil, "", time.Time{}, fmt.Errorf("failed %v: %v", repoInfo.Name, fmt.Errorf("No trust data for %s", namedTagged.Tag()))
 	}
 
 	r, err := convertTarget(t.Target)
 	if err != nil {
-		return nil, err
+		return nil, "", time.Time{}, err
 
 	}
-	return reference.WithDigest(namedTagged, r.digest)
+
+	expires, err := tufExpiry(d.trustBaseDir, repoInfo.Name.String(), data.CanonicalRootRole,
+		data.CanonicalTimestampRole, data.CanonicalSnapshotRole, data.CanonicalTargetsRole, t.Role)
+	if err != nil {
+		log.Warningf("Failed to read TUF metadata expiry for %s: %v", repoInfo.Name, err)
+	}
+
+	canonical, err := reference.WithDigest(namedTagged, r.digest)
+	return canonical, t.Role, expires, err
 }
 
 // notaryRepository returns a NotaryRepository which stores all the
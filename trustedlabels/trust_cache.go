@@ -0,0 +1,223 @@
+package trustedlabels
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/pal/log"
+)
+
+// trustCacheFile is the name of the file, relative to the trust store base
+// directory, in which trust verification results are persisted.
+const trustCacheFile = "pal_trust_cache.json"
+
+// trustCacheEntry is the outcome of a single Notary trust verification.
+type trustCacheEntry struct {
+	// Trusted reports whether the local digest matched the signed one.
+	Trusted bool `json:"trusted"`
+	// RemoteDigest is the digest that Notary reported for the tag.
+	RemoteDigest string `json:"remote_digest"`
+	// Role is the TUF role that signed RemoteDigest.
+	Role string `json:"role"`
+	// Verified is the time at which Notary was consulted.
+	Verified time.Time `json:"verified"`
+	// Expires is the time after which the entry must not be used. It is never
+	// later than the expiry of the TUF metadata the result was based on.
+	Expires time.Time `json:"expires"`
+}
+
+// trustCache is a disk-backed cache of Notary trust verification results keyed
+// by (repository, tag, digest). It allows images that were previously verified
+// to be verified again while the Notary server is unreachable.
+type trustCache struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	entries map[string]trustCacheEntry
+	now     func() time.Time
+
+	hits, misses uint64
+}
+
+// newTrustCache returns a trustCache persisted to path whose entries live at
+// most ttl. Existing entries in path are loaded; an unreadable cache file is
+// logged and otherwise ignored.
+func newTrustCache(path string, ttl time.Duration) *trustCache {
+	c := &trustCache{
+		path:    path,
+		ttl:     ttl,
+		entries: make(map[string]trustCacheEntry),
+		now:     time.Now,
+	}
+	buf, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		log.Warningf("Failed to read trust cache %s: %v", path, err)
+	default:
+		if err := json.Unmarshal(buf, &c.entries); err != nil {
+			log.Warningf("Ignoring corrupted trust cache %s: %v", path, err)
+			c.entries = make(map[string]trustCacheEntry)
+		}
+	}
+	return c
+}
+
+func trustCacheKey(name, digest string) string {
+	return name + "@" + digest
+}
+
+// get returns the unexpired entry for the image name (repository:tag) and
+// digest, if any.
+func (c *trustCache) get(name, digest string) (trustCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[trustCacheKey(name, digest)]
+	if !ok || !c.now().Before(entry.Expires) {
+		c.misses++
+		return trustCacheEntry{}, false
+	}
+	c.hits++
+	return entry, true
+}
+
+func (c *trustCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries)}
+}
+
+// flush removes every entry, from disk too.
+func (c *trustCache) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]trustCacheEntry)
+	return c.save()
+}
+
+// put records the outcome of a trust verification. The entry expires after the
+// cache TTL or at tufExpires, whichever comes first. A zero tufExpires means
+// the expiry of the metadata is unknown, in which case only the TTL applies.
+func (c *trustCache) put(name, digest string, trusted bool, remoteDigest, role string, tufExpires time.Time) {
+	now := c.now()
+	expires := now.Add(c.ttl)
+	if !tufExpires.IsZero() && tufExpires.Before(expires) {
+		expires = tufExpires
+	}
+	if !now.Before(expires) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[trustCacheKey(name, digest)] = trustCacheEntry{
+		Trusted:      trusted,
+		RemoteDigest: remoteDigest,
+		Role:         role,
+		Verified:     now,
+		Expires:      expires,
+	}
+	for key, entry := range c.entries {
+		if !now.Before(entry.Expires) {
+			delete(c.entries, key)
+		}
+	}
+	if err := c.save(); err != nil {
+		log.Warningf("Failed to persist trust cache %s: %v", c.path, err)
+	}
+}
+
+// check fails if the cache holds entries but none of them was verified within
+// the TTL, meaning that the trust server has not been consulted successfully
+// for that long.
+func (c *trustCache) check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) == 0 {
+		return nil
+	}
+	var latest time.Time
+	for _, entry := range c.entries {
+		if entry.Verified.After(latest) {
+			latest = entry.Verified
+		}
+	}
+	if c.now().Sub(latest) > c.ttl {
+		return fmt.Errorf("trust cache %s was last refreshed at %v, more than %v ago", c.path, latest, c.ttl)
+	}
+	return nil
+}
+
+// save atomically writes the cache to disk. c.mu must be held.
+func (c *trustCache) save() error {
+	buf, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), trustCacheFile)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
+
+// tufExpiry returns the earliest expiry among the locally cached TUF metadata
+// files of the given roles for gun. Roles whose metadata is not present in the
+// trust store are skipped.
+func tufExpiry(trustBaseDir, gun string, roles ...string) (time.Time, error) {
+	var earliest time.Time
+	metadataDir := filepath.Join(trustBaseDir, "tuf", filepath.FromSlash(gun), "metadata")
+	for _, role := range roles {
+		buf, err := ioutil.ReadFile(filepath.Join(metadataDir, filepath.FromSlash(role)+".json"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		var meta struct {
+			Signed struct {
+				Expires time.Time `json:"expires"`
+			} `json:"signed"`
+		}
+		if err := json.Unmarshal(buf, &meta); err != nil {
+			return time.Time{}, err
+		}
+		if earliest.IsZero() || meta.Signed.Expires.Before(earliest) {
+			earliest = meta.Signed.Expires
+		}
+	}
+	return earliest, nil
+}
+
+// checkTUFExpiry fails if the locally cached TUF metadata of one of the
+// repositories guns, for the given roles, expired before now.
+func checkTUFExpiry(trustBaseDir string, now time.Time, guns []string, roles ...string) error {
+	for _, gun := range guns {
+		expires, err := tufExpiry(trustBaseDir, gun, roles...)
+		if err != nil {
+			return fmt.Errorf("failed to read TUF metadata of %s: %v", gun, err)
+		}
+		if !expires.IsZero() && expires.Before(now) {
+			return fmt.Errorf("TUF metadata of %s expired at %v", gun, expires)
+		}
+	}
+	return nil
+}
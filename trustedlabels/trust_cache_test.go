@@ -0,0 +1,116 @@
+package trustedlabels
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrustCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pal-trust-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, trustCacheFile)
+
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	c := newTrustCache(path, time.Hour)
+	c.now = func() time.Time { return now }
+
+	c.put("foo:latest", "sha256:aaaa", true, "sha256:aaaa", "targets/releases", time.Time{})
+	c.put("bar:latest", "sha256:bbbb", true, "sha256:bbbb", "targets", now.Add(time.Minute))
+
+	// entries must survive a restart
+	c = newTrustCache(path, time.Hour)
+	c.now = func() time.Time { return now.Add(30 * time.Second) }
+	if entry, ok := c.get("foo:latest", "sha256:aaaa"); !ok || !entry.Trusted || entry.Role != "targets/releases" {
+		t.Errorf("want trusted entry for foo:latest, got %+v, %v", entry, ok)
+	}
+	if _, ok := c.get("foo:latest", "sha256:cccc"); ok {
+		t.Error("want no entry for an unknown digest")
+	}
+
+	// the TUF metadata expiry takes precedence over the TTL
+	c.now = func() time.Time { return now.Add(2 * time.Minute) }
+	if _, ok := c.get("bar:latest", "sha256:bbbb"); ok {
+		t.Error("want entry for bar:latest to expire with its TUF metadata")
+	}
+	if _, ok := c.get("foo:latest", "sha256:aaaa"); !ok {
+		t.Error("want entry for foo:latest to be valid until its TTL")
+	}
+
+	c.now = func() time.Time { return now.Add(time.Hour) }
+	if _, ok := c.get("foo:latest", "sha256:aaaa"); ok {
+		t.Error("want entry for foo:latest to expire after its TTL")
+	}
+	if stats := c.stats(); stats != (CacheStats{Hits: 2, Misses: 3, Entries: 2}) {
+		t.Errorf("want 2 hits, 3 misses and 2 entries, got %+v", stats)
+	}
+	if err := c.check(); err != nil {
+		t.Errorf("want the cache refreshed within its TTL, got %v", err)
+	}
+	c.now = func() time.Time { return now.Add(time.Hour + time.Second) }
+	if err := c.check(); err == nil {
+		t.Error("want the cache reported as past its TTL")
+	}
+
+	// a flushed cache is empty after a restart too
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	c = newTrustCache(path, time.Hour)
+	c.now = func() time.Time { return now }
+	if _, ok := c.get("foo:latest", "sha256:aaaa"); ok {
+		t.Error("want no entry for foo:latest after a flush")
+	}
+}
+
+func TestTUFExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pal-tuf-expiry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metadataDir := filepath.Join(dir, "tuf", "docker.io", "library", "foo", "metadata")
+	if err := os.MkdirAll(filepath.Join(metadataDir, "targets"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for role, expires := range map[string]string{
+		"root":             "2027-01-01T00:00:00Z",
+		"timestamp":        "2017-06-14T00:00:00Z",
+		"targets/releases": "2020-01-01T00:00:00Z",
+	} {
+		meta := []byte(`{"signed":{"expires":"` + expires + `"}}`)
+		if err := ioutil.WriteFile(filepath.Join(metadataDir, role+".json"), meta, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := tufExpiry(dir, "docker.io/library/foo", "root", "timestamp", "snapshot", "targets/releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2017, 6, 14, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("want expiry %v, got %v", want, got)
+	}
+
+	roles := []string{"root", "timestamp", "snapshot", "targets/releases"}
+	guns := []string{"docker.io/library/foo"}
+	if err := checkTUFExpiry(dir, time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC), guns, roles...); err != nil {
+		t.Errorf("want fresh TUF metadata, got %v", err)
+	}
+	if err := checkTUFExpiry(dir, time.Date(2017, 6, 15, 0, 0, 0, 0, time.UTC), guns, roles...); err == nil {
+		t.Error("want the TUF metadata of docker.io/library/foo reported as expired")
+	}
+	// repositories which were not looked up do not matter
+	if err := checkTUFExpiry(dir, time.Date(2017, 6, 15, 0, 0, 0, 0, time.UTC), nil, roles...); err != nil {
+		t.Errorf("want the unused repository ignored, got %v", err)
+	}
+	if err := checkTUFExpiry(dir, time.Now(), []string{"docker.io/library/bar"}, roles...); err != nil {
+		t.Errorf("want a repository without metadata to pass, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package pal
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/cloudflare/pal/log"
+	"github.com/cloudflare/pal/trustedlabels"
+)
+
+// WhoAmI is what pald resolved for the process sending a whoami request, to
+// debug why it is denied a label. It holds no secret.
+type WhoAmI struct {
+	UID int `json:"uid,omitempty"`
+	GID int `json:"gid,omitempty"`
+	PID int `json:"pid,omitempty"`
+	// SecurityContext is the SELinux context or AppArmor profile of the
+	// caller.
+	SecurityContext string `json:"security_context,omitempty"`
+	// Certificate is the identity of the client certificate of a remote
+	// caller.
+	Certificate string `json:"certificate,omitempty"`
+
+	ContainerID string `json:"container_id,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"image_digest,omitempty"`
+	// Trusted reports whether the image digest was verified against its
+	// signature, by SignerRole.
+	Trusted    bool   `json:"trusted"`
+	SignerRole string `json:"signer_role,omitempty"`
+	Pod        string `json:"pod,omitempty"`
+
+	// LabelsEnabled reports whether pald checks the labels of the secrets.
+	LabelsEnabled bool `json:"labels_enabled"`
+	// Labels are the labels granted to the caller, sorted.
+	Labels []string `json:"labels"`
+	// Sources explains which sources of a composite retriever granted each
+	// label.
+	Sources    map[string]string `json:"sources,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Errors are the errors of the labels retriever, or of its sources.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// whoAmI resolves the identity of the peer of c like for a decryption
+// request, and describes it.
+func (s *Server) whoAmI(c *conn) *decryptionResponse {
+	st := s.acquireState()
+	defer st.release()
+	w := &WhoAmI{LabelsEnabled: st.labelsRetriever != nil || c.cert != nil}
+	if c.Ucred != nil {
+		w.UID, w.GID, w.PID = int(c.Uid), int(c.Gid), int(c.Pid)
+	}
+	if c.securityContext != nil {
+		w.SecurityContext = c.securityContext.Raw
+	}
+
+	var (
+		identity *trustedlabels.Identity
+		err      error
+	)
+	if c.cert != nil {
+		identity, err = st.certRetriever.IdentityForCertificate(c.cert)
+	} else if st.labelsRetriever != nil {
+		identity, err = st.labelsRetriever.IdentityForPID(int(c.Pid))
+		if err == nil {
+			if err = c.verifyPeer(); err != nil {
+				// the identity may be that of another process
+				identity = nil
+			} else {
+				identity.Labels = st.grantSecurityContextLabels(identity.Labels, c.securityContext)
+			}
+		}
+	}
+	if err != nil {
+		w.Errors = append(w.Errors, err.Error())
+	}
+	if identity != nil {
+		w.describe(identity)
+	}
+	log.Infof("Answered whoami request of uid %d pid %d", w.UID, w.PID)
+	return &decryptionResponse{WhoAmI: w}
+}
+
+// describe sets the fields of w from identity.
+func (w *WhoAmI) describe(identity *trustedlabels.Identity) {
+	w.Certificate = identity.CertificateID
+	w.ContainerID = identity.ContainerID
+	w.Image, w.ImageDigest = identity.ImageName, identity.ImageDigest
+	w.Trusted, w.SignerRole = identity.SignerRole != "", identity.SignerRole
+	if identity.Pod != "" {
+		w.Pod = identity.PodNamespace + "/" + identity.Pod
+	}
+	w.Labels = make([]string, 0, len(identity.Labels))
+	for label := range identity.Labels {
+		w.Labels = append(w.Labels, label)
+	}
+	sort.Strings(w.Labels)
+	w.Attributes = identity.Attributes
+	if trace := identity.Trace; trace != nil {
+		w.Sources = make(map[string]string)
+		for _, label := range trace.Labels() {
+			w.Sources[label] = trace.Explain(label)
+		}
+		for _, source := range trace.Sources {
+			if err, ok := trace.Errors[source]; ok {
+				w.Errors = append(w.Errors, fmt.Sprintf("%s: %v", source, err))
+			}
+		}
+	}
+}
+
+// QueryWhoAmI asks the pald listening on the unix socket socketAddr what it
+// resolved for the calling process.
+func QueryWhoAmI(socketAddr string) (*WhoAmI, error) {
+	return newClientV2(&ConfigEntry{}, socketAddr).whoAmI()
+}
+
+// QueryWhoAmITLS is like QueryWhoAmI, but connects to pald at the TCP address
+// addr using the given TLS configuration, which must include a client
+// certificate.
+func QueryWhoAmITLS(addr string, tlsConfig *tls.Config) (*WhoAmI, error) {
+	c := newClientV2(&ConfigEntry{}, addr)
+	c.dialFunc = func(_, _ string) (net.Conn, error) {
+		return tls.Dial("tcp", addr, tlsConfig)
+	}
+	return c.whoAmI()
+}
+
+func (c *clientV2) whoAmI() (*WhoAmI, error) {
+	if err := c.handshake(); err != nil {
+		return nil, err
+	}
+	defer c.closeSession()
+	if c.server == nil || !c.server.hasFeature(featureWhoAmI) {
+		return nil, errors.New("pald does not support whoami requests")
+	}
+	dresp, err := c.doRPCdecryptionRequest(&decryptionRequest{WhoAmI: true})
+	if err != nil {
+		return nil, err
+	}
+	if dresp.WhoAmI == nil {
+		return nil, errors.New("pald did not answer the whoami request")
+	}
+	return dresp.WhoAmI, nil
+}
@@ -0,0 +1,70 @@
+package pal
+
+import (
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/cloudflare/pal/trustedlabels"
+
+	"github.com/joshlf/testutil"
+)
+
+func TestWhoAmI(t *testing.T) {
+	listener, tempdir := mustListenUnixSocket(t)
+	defer os.RemoveAll(tempdir)
+	defer listener.Close()
+
+	server := mustPGPServer(t, trustedlabels.NewUnion(
+		trustedlabels.Source{Name: "docker", Retriever: &identityRetriever{
+			Labels:      map[string]struct{}{"app-foo": {}, "app-bar": {}},
+			ContainerID: "0123456789abcdef",
+			ImageName:   "foo:latest",
+			ImageDigest: "sha256:aaaa",
+			SignerRole:  "targets/releases",
+		}},
+		trustedlabels.Source{Name: "k8s", Retriever: unresolvedRetriever{}},
+	))
+	go server.ServeRPC(listener)
+
+	w, err := QueryWhoAmI(listener.Addr().String())
+	testutil.MustPrefix(t, "could not query whoami", err)
+	want := &WhoAmI{
+		UID:           os.Getuid(),
+		GID:           os.Getgid(),
+		PID:           os.Getpid(),
+		ContainerID:   "0123456789abcdef",
+		Image:         "foo:latest",
+		ImageDigest:   "sha256:aaaa",
+		Trusted:       true,
+		SignerRole:    "targets/releases",
+		LabelsEnabled: true,
+		Labels:        []string{"app-bar", "app-foo"},
+		Sources: map[string]string{
+			"app-bar": "granted by docker; failed in k8s (image is not signed)",
+			"app-foo": "granted by docker; failed in k8s (image is not signed)",
+		},
+		Errors: []string{"k8s: image is not signed"},
+	}
+	w.SecurityContext, w.Attributes = "", nil
+	if !reflect.DeepEqual(w, want) {
+		t.Errorf("want %+v, got %+v", want, w)
+	}
+
+	// the caller learns why it cannot be identified
+	server.state().labelsRetriever = unresolvedRetriever{}
+	w, err = QueryWhoAmI(listener.Addr().String())
+	testutil.MustPrefix(t, "could not query whoami", err)
+	if len(w.Labels) != 0 || !reflect.DeepEqual(w.Errors, []string{"image is not signed"}) {
+		t.Errorf("want no label and the retriever error, got %+v", w)
+	}
+
+	// nothing is reported of a process which replaced the peer
+	server.state().labelsRetriever = &identityRetriever{ContainerID: "0123456789abcdef", Labels: map[string]struct{}{"app-foo": {}}}
+	c := &conn{Ucred: &syscall.Ucred{Pid: int32(os.Getpid())}, startTime: 1}
+	w = server.whoAmI(c).WhoAmI
+	if w.ContainerID != "" || len(w.Labels) != 0 || !reflect.DeepEqual(w.Errors, []string{errPeerChanged.Error()}) {
+		t.Errorf("want no identity and the peer change error, got %+v", w)
+	}
+}